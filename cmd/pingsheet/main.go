@@ -9,6 +9,7 @@ import (
 	"os"
 
 	"github.com/adamkirchberger/pingsheet"
+	ping "github.com/adamkirchberger/pingsheet/pkg"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -21,6 +22,11 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "reflect" {
+		runReflector(os.Args[2:])
+		return
+	}
+
 	sheet := flag.String("sheet", "", "google sheet ID")
 	credentials := flag.String("credentials", "", "path to key file")
 	hostname := flag.String("hostname", "", "hostname")
@@ -36,13 +42,7 @@ func main() {
 		return
 	}
 
-	// Logging
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	if *debug {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	}
+	setupLogging(*debug)
 
 	// Handle required args
 	if *sheet == "" {
@@ -74,3 +74,54 @@ func main() {
 	}
 	p.Run()
 }
+
+// runReflector will answer the UDP echo and TWAMP-light probes of other
+// pingsheet hosts until the process is stopped
+func runReflector(args []string) {
+	flags := flag.NewFlagSet("reflect", flag.ExitOnError)
+	listen := flags.String("listen", fmt.Sprintf(":%d", ping.DefaultReflectPort), "address to listen on for UDP echo probes, empty to disable")
+	twamp := flags.String("twamp", "", "address to listen on for TWAMP-light probes such as :862")
+	debug := flags.Bool("debug", false, "enable debug")
+	flags.Parse(args)
+
+	setupLogging(*debug)
+
+	if *listen == "" && *twamp == "" {
+		fmt.Println("listen or twamp address must be supplied!")
+		os.Exit(1)
+	}
+
+	errs := make(chan error, 2)
+	if *listen != "" {
+		r, err := ping.NewReflector(*listen)
+		if err != nil {
+			log.Error().Msgf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		log.Info().Msgf("Reflecting UDP probes on %s", r.Addr())
+		go func() { errs <- r.Serve() }()
+	}
+	if *twamp != "" {
+		r, err := ping.NewTWAMPReflector(*twamp)
+		if err != nil {
+			log.Error().Msgf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		log.Info().Msgf("Reflecting TWAMP-light probes on %s", r.Addr())
+		go func() { errs <- r.Serve() }()
+	}
+
+	err := <-errs
+	log.Error().Msgf("Error: %s\n", err)
+	os.Exit(1)
+}
+
+// setupLogging will configure the logger
+func setupLogging(debug bool) {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if debug {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+}
@@ -4,7 +4,8 @@ go 1.14
 
 require (
 	github.com/rs/zerolog v1.19.0
-	github.com/sparrc/go-ping v0.0.0-20190613174326-4e5b6552494c
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
+	golang.org/x/sys v0.0.0-20200331124033-c3d80250170d
 	google.golang.org/api v0.28.0
 )
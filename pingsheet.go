@@ -4,8 +4,10 @@
 package pingsheet
 
 import (
+	"context"
 	"errors"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/adamkirchberger/pingsheet/pkg/config"
@@ -25,12 +27,20 @@ type Pingsheet struct {
 	svc        *sheets.Service
 	host       *config.Host
 	privileged bool
+	routes     *ping.RouteTracker
+	streams    *ping.Streams
 }
 
 const (
 	configPullInterval int = 300 // Interval in secs between pulling new config
 )
 
+// pathsHeaders are the headers used in the host paths sheet
+var pathsHeaders = []string{"TIMESTAMP", "TARGET", "HOPS", "PATH"}
+
+// eventsHeaders are the headers used in the host events sheet
+var eventsHeaders = []string{"TIMESTAMP", "TARGET", "EVENT", "OLD", "NEW"}
+
 // NewPingsheet is used to create a new Pingsheet instance
 func NewPingsheet(sheetID, keyPath, hostname, secret string) (*Pingsheet, error) {
 	// Check if elevated privileges are required and present
@@ -52,6 +62,7 @@ func NewPingsheet(sheetID, keyPath, hostname, secret string) (*Pingsheet, error)
 		svc:        svc,
 		host:       nil,
 		privileged: pingPrivs,
+		routes:     ping.NewRouteTracker(),
 	}
 
 	err = p.pullLatestConfig()
@@ -96,7 +107,6 @@ func (p *Pingsheet) Run() {
 		// Run tests
 		startTime := time.Now()
 		log.Debug().Msgf("Ping targets start")
-		p.prepHeaders()
 		p.pingTargets()
 
 		// Tests complete
@@ -132,6 +142,7 @@ func (p *Pingsheet) pullLatestConfig() error {
 
 	// Update with new host
 	p.host = host
+	p.updateStreams()
 
 	// Update our host ID with the worksheet ID
 	worksheetID, err := gsheets.GetWorksheetID(p.svc, p.SheetID, p.host.Hostname)
@@ -146,9 +157,48 @@ func (p *Pingsheet) pullLatestConfig() error {
 	return nil
 }
 
-// clearOldRows ensures that rows in a host sheet do not exceed MAXROWS
+// updateStreams will start or stop continuous sessions to match the host mode
+func (p *Pingsheet) updateStreams() {
+	if p.host.Mode != config.ModeContinuous {
+		if p.streams != nil {
+			log.Info().Msgf("Stop continuous sessions")
+			p.streams.Close()
+			p.streams = nil
+		}
+		return
+	}
+
+	if p.streams == nil {
+		log.Info().Msgf("Start continuous sessions")
+		p.streams = ping.NewStreams()
+	}
+}
+
+// clearOldRows ensures that rows in the host sheets do not exceed MAXROWS
 func (p *Pingsheet) clearOldRows() error {
-	currTotal, err := gsheets.GetWorksheetTotalRows(p.svc, p.SheetID, p.host.Hostname)
+	err := p.clearWorksheetRows(p.host.Hostname, p.host.ID)
+	if err != nil {
+		return err
+	}
+
+	if !p.hasTracedTargets() {
+		return nil
+	}
+	for _, worksheet := range []string{p.pathsWorksheet(), p.eventsWorksheet()} {
+		worksheetID, err := gsheets.GetWorksheetID(p.svc, p.SheetID, worksheet)
+		if err != nil {
+			return err
+		}
+		if err = p.clearWorksheetRows(worksheet, worksheetID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearWorksheetRows ensures that rows in a worksheet do not exceed MAXROWS
+func (p *Pingsheet) clearWorksheetRows(worksheet string, worksheetID int64) error {
+	currTotal, err := gsheets.GetWorksheetTotalRows(p.svc, p.SheetID, worksheet)
 	if err != nil {
 		log.Error().Msgf("Unable to get total rows: %s", err)
 	}
@@ -165,21 +215,20 @@ func (p *Pingsheet) clearOldRows() error {
 	deleteCount := currTotal - int64(p.host.MaxRows)
 
 	// Do delete
-	err = gsheets.DeleteLastRows(p.svc, p.SheetID, p.host.ID, deleteCount)
+	err = gsheets.DeleteLastRows(p.svc, p.SheetID, worksheetID, deleteCount)
 	if err != nil {
 		return err
 	}
 
-	log.Debug().Msgf("Cleared %d rows", deleteCount)
+	log.Debug().Msgf("Cleared %d rows from %s", deleteCount, worksheet)
 	return nil
 }
 
 // makeMissingTargetHeaders will return a slice of strings with all the
-// headers which are required for the configured targets.
-func (p *Pingsheet) makeMissingTargetHeaders(headers []string) []string {
-	metrics := []string{"RTT", "JTT", "SENT", "DROPS"}
-	for _, target := range p.host.Targets {
-		for _, metric := range metrics {
+// headers which are required for the targets.
+func (p *Pingsheet) makeMissingTargetHeaders(headers []string, targets []config.Target) []string {
+	for _, target := range targets {
+		for _, metric := range ping.Metrics(target, p.host.Metrics) {
 			if !contains(headers, target.Name+"_"+metric) {
 				headers = append(headers, target.Name+"_"+metric)
 			}
@@ -188,8 +237,9 @@ func (p *Pingsheet) makeMissingTargetHeaders(headers []string) []string {
 	return headers
 }
 
-// prepHeaders will ensure that all headers are in host sheet ready for results
-func (p *Pingsheet) prepHeaders() {
+// prepHeaders will ensure that all headers are in host sheet ready for the
+// results of the targets
+func (p *Pingsheet) prepHeaders(targets []config.Target) {
 	log.Debug().Msgf("Prepare headers")
 	// Get current headers
 	cols, err := gsheets.GetHeadersFromSheet(p.svc, p.SheetID, p.host.Hostname)
@@ -198,7 +248,7 @@ func (p *Pingsheet) prepHeaders() {
 	}
 
 	// Create slice of all headers we need plus current ones
-	newHeaders := p.makeMissingTargetHeaders(cols)
+	newHeaders := p.makeMissingTargetHeaders(cols, targets)
 
 	// Update headers on sheet
 	err = gsheets.SetHeaders(p.svc, p.SheetID, p.host.Hostname, newHeaders)
@@ -215,16 +265,58 @@ func (p *Pingsheet) prepHeaders() {
 	if err != nil {
 		log.Warn().Msgf("Got an error when setting latest row: %s", err)
 	}
+
+	if p.hasTracedTargets() {
+		p.prepWorksheet(p.pathsWorksheet(), pathsHeaders)
+		p.prepWorksheet(p.eventsWorksheet(), eventsHeaders)
+	}
+}
+
+// prepWorksheet will ensure that a host worksheet exists with headers
+func (p *Pingsheet) prepWorksheet(worksheet string, headers []string) {
+	cols, err := gsheets.GetHeadersFromSheet(p.svc, p.SheetID, worksheet)
+	if err == nil && len(cols) > 0 {
+		return
+	}
+
+	log.Info().Msgf("Create worksheet %s", worksheet)
+	gsheets.MakeWorksheet(p.svc, p.SheetID, worksheet)
+
+	err = gsheets.SetHeaders(p.svc, p.SheetID, worksheet, headers)
+	if err != nil {
+		log.Warn().Msgf("Got an error when setting headers: %s", err)
+	}
+
+	err = gsheets.AddLatestRow(p.svc, p.SheetID, worksheet)
+	if err != nil {
+		log.Warn().Msgf("Got an error when setting latest row: %s", err)
+	}
 }
 
 // pingTargets is what runs the ping tests to each target, gathers the results
 // and uploads the results to the host sheet.
 func (p *Pingsheet) pingTargets() {
 	log.Debug().Msg("Request ping test to all targets")
-	results := ping.Run(p.host.Count, p.host.Targets, p.privileged)
+	// Probes must finish before the next test is due
+	ctx, cancel := context.WithTimeout(context.Background(), p.host.Interval)
+	defer cancel()
+	results := ping.Run(ctx, p.host.Targets, ping.Options{
+		Count:       p.host.Count,
+		Concurrency: p.host.Concurrency,
+		Privileged:  p.privileged,
+		Streams:     p.streams,
+	})
 
 	log.Debug().Msgf("Ping returned %d target results", len(results))
 
+	// Headers are prepared from the results as expanded targets have a
+	// result for each address they resolve to
+	targets := make([]config.Target, len(results))
+	for idx, r := range results {
+		targets[idx] = r.Target
+	}
+	p.prepHeaders(targets)
+
 	log.Debug().Msg("Get headers for positions")
 	cols, err := gsheets.GetHeadersFromSheet(p.svc, p.SheetID, p.host.Hostname)
 	if err != nil {
@@ -247,21 +339,13 @@ func (p *Pingsheet) pingTargets() {
 	newUpload[0] = timestamp
 
 	for _, r := range results {
-		// RTT
-		rttIdx := colIndex(cols, r.Target.Name+"_RTT")
-		newUpload[rttIdx] = r.RTT
-
-		// JTT
-		jttIdx := colIndex(cols, r.Target.Name+"_JTT")
-		newUpload[jttIdx] = r.JTT
-
-		// SENT
-		sentIdx := colIndex(cols, r.Target.Name+"_SENT")
-		newUpload[sentIdx] = r.Sent
-
-		// DROPS
-		dropsIdx := colIndex(cols, r.Target.Name+"_DROPS")
-		newUpload[dropsIdx] = r.Drops
+		for metric, val := range r.Values() {
+			idx := colIndex(cols, r.Target.Name+"_"+metric)
+			if idx < 0 {
+				continue
+			}
+			newUpload[idx] = val
+		}
 	}
 
 	log.Debug().Msg("Upload results")
@@ -271,6 +355,87 @@ func (p *Pingsheet) pingTargets() {
 	} else {
 		log.Debug().Msg("Upload successful")
 	}
+
+	p.uploadPaths(results, timestamp)
+	p.uploadRouteChanges(results)
+}
+
+// uploadPaths will add the discovered path of each traced target to the host
+// paths sheet
+func (p *Pingsheet) uploadPaths(results []ping.Result, timestamp string) {
+	rows := make([][]interface{}, 0)
+	for _, r := range results {
+		if r.Path == nil {
+			continue
+		}
+		// Traces which did not reach the target have no hop count
+		var hops interface{} = r.Hops
+		if r.Hops == 0 {
+			hops = ""
+		}
+		rows = append(rows, []interface{}{
+			timestamp,
+			r.Target.Name,
+			hops,
+			strings.Join(r.Path, " > "),
+		})
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	log.Debug().Msgf("Upload %d paths", len(rows))
+	err := gsheets.AddRows(p.svc, p.SheetID, p.pathsWorksheet(), rows)
+	if err != nil {
+		log.Error().Msgf("Error uploading paths: %s", err)
+	}
+}
+
+// uploadRouteChanges will compare the discovered paths with the last known
+// paths and add an event to the host events sheet for each route change
+func (p *Pingsheet) uploadRouteChanges(results []ping.Result) {
+	changes := p.routes.Update(results)
+	if len(changes) == 0 {
+		return
+	}
+
+	rows := make([][]interface{}, 0, len(changes))
+	for _, c := range changes {
+		log.Warn().Msgf("Route changed for target `%s`", c.Target.Name)
+		rows = append(rows, []interface{}{
+			c.Time.UTC().Format("2006-01-02T15:04:05"),
+			c.Target.Name,
+			"ROUTE_CHANGED",
+			strings.Join(c.Old, " > "),
+			strings.Join(c.New, " > "),
+		})
+	}
+
+	log.Debug().Msgf("Upload %d route changes", len(rows))
+	err := gsheets.AddRows(p.svc, p.SheetID, p.eventsWorksheet(), rows)
+	if err != nil {
+		log.Error().Msgf("Error uploading route changes: %s", err)
+	}
+}
+
+// pathsWorksheet returns the name of the host paths sheet
+func (p *Pingsheet) pathsWorksheet() string {
+	return p.host.Hostname + "_PATHS"
+}
+
+// eventsWorksheet returns the name of the host events sheet
+func (p *Pingsheet) eventsWorksheet() string {
+	return p.host.Hostname + "_EVENTS"
+}
+
+// hasTracedTargets checks if any host targets have path discovery enabled
+func (p *Pingsheet) hasTracedTargets() bool {
+	for _, target := range p.host.Targets {
+		if target.Trace != "" {
+			return true
+		}
+	}
+	return false
 }
 
 // contains is a handy function to check for string in a slice of strings
@@ -0,0 +1,54 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dscpClasses are the DSCP values of the standard per hop behaviours which
+// can be used by name instead of number
+var dscpClasses = map[string]int{
+	"BE": 0, "DF": 0, "LE": 1, "EF": 46, "VA": 44,
+	"CS0": 0, "CS1": 8, "CS2": 16, "CS3": 24, "CS4": 32, "CS5": 40, "CS6": 48, "CS7": 56,
+	"AF11": 10, "AF12": 12, "AF13": 14,
+	"AF21": 18, "AF22": 20, "AF23": 22,
+	"AF31": 26, "AF32": 28, "AF33": 30,
+	"AF41": 34, "AF42": 36, "AF43": 38,
+}
+
+// parseDSCP will parse a comma separated list of DSCP class names such as
+// `EF,AF41,BE` or values from `0` to `63`, the classes are returned in upper
+// case
+func parseDSCP(key, val string) ([]string, error) {
+	classes := make([]string, 0)
+	for _, class := range strings.Split(val, ",") {
+		class = strings.ToUpper(strings.TrimSpace(class))
+		if class == "" {
+			continue
+		}
+		if _, err := dscpValue(class); err != nil {
+			return nil, fmt.Errorf("`%s` must be a class such as EF or AF41, or a number between 0 and 63", key)
+		}
+		classes = append(classes, class)
+	}
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("`%s` must not be empty", key)
+	}
+	return classes, nil
+}
+
+// dscpValue returns the DSCP value of a class name or number
+func dscpValue(class string) (int, error) {
+	if val, ok := dscpClasses[strings.ToUpper(class)]; ok {
+		return val, nil
+	}
+	val, err := strconv.Atoi(class)
+	if err != nil || val < 0 || val > 63 {
+		return 0, fmt.Errorf("unknown DSCP class `%s`", class)
+	}
+	return val, nil
+}
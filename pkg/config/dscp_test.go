@@ -0,0 +1,98 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDSCP(t *testing.T) {
+	tests := []struct {
+		name    string
+		val     string
+		want    []string
+		wantErr bool
+	}{
+		{"class name", "EF", []string{"EF"}, false},
+		{"lower case", "af41", []string{"AF41"}, false},
+		{"number", "46", []string{"46"}, false},
+		{"list", "EF, AF41,,BE", []string{"EF", "AF41", "BE"}, false},
+		{"unknown class", "AF51", nil, true},
+		{"number too high", "64", nil, true},
+		{"negative number", "-1", nil, true},
+		{"empty", " , ", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDSCP("dscp", tt.val)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDSCP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDSCP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDSCPValue(t *testing.T) {
+	tests := []struct {
+		class string
+		want  int
+	}{
+		{"BE", 0},
+		{"DF", 0},
+		{"LE", 1},
+		{"CS1", 8},
+		{"AF11", 10},
+		{"AF23", 22},
+		{"AF31", 26},
+		{"AF41", 34},
+		{"AF43", 38},
+		{"CS5", 40},
+		{"VA", 44},
+		{"EF", 46},
+		{"CS6", 48},
+		{"CS7", 56},
+		{"ef", 46},
+		{"0", 0},
+		{"63", 63},
+	}
+	for _, tt := range tests {
+		t.Run(tt.class, func(t *testing.T) {
+			got, err := dscpValue(tt.class)
+			if err != nil {
+				t.Fatalf("dscpValue() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("dscpValue() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandClasses(t *testing.T) {
+	target, err := NewTarget("gw=10.0.0.1 dscp=EF,af41,0")
+	if err != nil {
+		t.Fatalf("NewTarget() error = %v", err)
+	}
+	got := target.expandClasses()
+	want := []struct {
+		name string
+		dscp int
+	}{
+		{"gw_EF", 46},
+		{"gw_AF41", 34},
+		{"gw_0", 0},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expandClasses() returned %d targets, want %d", len(got), len(want))
+	}
+	for idx, w := range want {
+		if got[idx].Name != w.name || got[idx].DSCP != w.dscp {
+			t.Errorf("expandClasses()[%d] = %s DSCP %d, want %s DSCP %d", idx, got[idx].Name, got[idx].DSCP, w.name, w.dscp)
+		}
+	}
+}
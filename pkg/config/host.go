@@ -5,7 +5,9 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/adamkirchberger/pingsheet/pkg/gsheets"
@@ -13,13 +15,32 @@ import (
 
 // Host model
 type Host struct {
-	ID       int64
-	Hostname string
-	Secret   string
-	Interval time.Duration
-	Count    int
-	MaxRows  int
-	Targets  []Target
+	ID          int64
+	Hostname    string
+	Secret      string
+	Interval    time.Duration
+	Count       int
+	MaxRows     int
+	MaxSweep    int
+	Concurrency int
+	Mode        string
+	Metrics     []string
+	Targets     []Target
+}
+
+// DefaultMetrics are the metrics reported when a host has no `METRICS`
+var DefaultMetrics = []string{"RTT", "JTT", "SENT", "DROPS"}
+
+// Test modes which can be set in `MODE`
+const (
+	ModeBurst      = "burst"      // Send `COUNT` probes to each target each interval
+	ModeContinuous = "continuous" // Send ICMP probes to each target without pausing
+)
+
+// SelectableMetrics are all the metrics which can be chosen in `METRICS`
+var SelectableMetrics = []string{
+	"RTT", "JTT", "SENT", "DROPS", "MIN", "MAX", "STDDEV", "P50", "P95", "P99",
+	"MAXLOSS", "RFACTOR", "MOS", "IPDV50", "IPDV95", "IPDV99", "DUPS", "REORDER",
 }
 
 // NewHost will create a new Host type from a Gsheet row
@@ -68,7 +89,81 @@ func NewHost(row gsheets.SheetRow) (*Host, error) {
 		return nil, errors.New("Host is missing `MAXROWS`")
 	}
 
-	newH.Targets = BuildTargets(row)
+	if val, ok := row["concurrency"]; ok && strings.TrimSpace(val.(string)) != "" {
+		valInt, err := strconv.Atoi(strings.TrimSpace(val.(string)))
+		if err != nil || valInt < 1 {
+			return nil, errors.New("`CONCURRENCY` must be number greater than zero")
+		}
+		newH.Concurrency = valInt
+	}
+
+	newH.Mode = ModeBurst
+	if val, ok := row["mode"]; ok && strings.TrimSpace(val.(string)) != "" {
+		mode := strings.ToLower(strings.TrimSpace(val.(string)))
+		if mode != ModeBurst && mode != ModeContinuous {
+			return nil, fmt.Errorf("`MODE` must be %s or %s", ModeBurst, ModeContinuous)
+		}
+		newH.Mode = mode
+	}
+
+	newH.Metrics = DefaultMetrics
+	if val, ok := row["metrics"]; ok && strings.TrimSpace(val.(string)) != "" {
+		metrics, err := parseMetrics(val.(string))
+		if err != nil {
+			return nil, err
+		}
+		newH.Metrics = metrics
+	}
+
+	newH.MaxSweep = DefaultMaxSweep
+	if val, ok := row["maxsweep"]; ok && strings.TrimSpace(val.(string)) != "" {
+		valInt, err := strconv.Atoi(strings.TrimSpace(val.(string)))
+		if err != nil || valInt < 1 {
+			return nil, errors.New("`MAXSWEEP` must be number greater than zero")
+		}
+		newH.MaxSweep = valInt
+	}
+
+	newH.Targets = BuildTargets(row, newH.MaxSweep)
+
+	// Targets use the host jitter algorithm unless they set their own
+	if val, ok := row["jitter"]; ok && strings.TrimSpace(val.(string)) != "" {
+		jitter, err := parseJitter("JITTER", strings.TrimSpace(val.(string)))
+		if err != nil {
+			return nil, err
+		}
+		for idx := range newH.Targets {
+			if newH.Targets[idx].Jitter == "" {
+				newH.Targets[idx].Jitter = jitter
+			}
+		}
+	}
 
 	return &newH, nil
 }
+
+// parseMetrics will parse a comma separated list of metrics
+func parseMetrics(val string) ([]string, error) {
+	metrics := make([]string, 0)
+	for _, metric := range strings.Split(val, ",") {
+		metric = strings.ToUpper(strings.TrimSpace(metric))
+		if metric == "" {
+			continue
+		}
+		if !isSelectableMetric(metric) {
+			return nil, fmt.Errorf("`METRICS` has unknown metric `%s`", metric)
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// isSelectableMetric checks if a metric can be chosen in `METRICS`
+func isSelectableMetric(metric string) bool {
+	for _, m := range SelectableMetrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
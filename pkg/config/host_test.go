@@ -0,0 +1,46 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package config
+
+import (
+	"testing"
+
+	"github.com/adamkirchberger/pingsheet/pkg/gsheets"
+)
+
+func TestNewHostMaxSweep(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxSweep    string
+		wantTargets int
+		wantErr     bool
+	}{
+		{"default", "", 254, false},
+		{"capped", "10", 10, false},
+		{"larger than subnet", "1000", 254, false},
+		{"zero", "0", 0, true},
+		{"negative", "-5", 0, true},
+		{"not a number", "many", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := gsheets.SheetRow{
+				"hostname": "probe01",
+				"secret":   "secret",
+				"interval": "60",
+				"count":    "5",
+				"maxrows":  "100",
+				"maxsweep": tt.maxSweep,
+				"target_1": "10.0.0.0/24",
+			}
+			h, err := NewHost(row)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewHost() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(h.Targets) != tt.wantTargets {
+				t.Errorf("NewHost() has %d targets, want %d", len(h.Targets), tt.wantTargets)
+			}
+		})
+	}
+}
@@ -4,25 +4,531 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/adamkirchberger/pingsheet/pkg/gsheets"
+
+	"github.com/rs/zerolog/log"
 )
 
+// Target model
+//
+// Options which are not set have a zero value and use the host defaults.
 type Target struct {
-	Name string
+	Name     string
+	Scheme   string
+	Address  string
+	Trace    string
+	Count    int
+	Interval time.Duration
+	Timeout  time.Duration
+	Size     int
+	MaxMTU   int // Largest MTU tested by `mtu://` targets
+	TTL      int
+	DSCP     int
+	Class    string // DSCP class such as EF, or a list of classes before expansion
+	Jitter   string
+	Family   string
+	Expand   bool
+	Source   string // Address or interface to send probes from
+	Netns    string // Linux network namespace to send probes from
+	VRF      string // Linux VRF device to bind probes to
+	IP       string // Resolved address to test, only set for expanded targets
+	Err      error  // Reason the target config is not valid, such targets are not tested
+}
+
+// TargetOptions are the options which can be set for a target either inline
+// as `key=value` after the target address or in a `KEY_n` column matching the
+// number of the `TARGET_n` column
+var TargetOptions = []string{
+	"alias", "trace", "count", "interval", "timeout", "size", "maxmtu", "ttl",
+	"dscp", "jitter", "family", "expand", "source", "netns", "vrf",
+}
+
+// hostTargetOptions are the target options which have a host default in the
+// column of the same name
+var hostTargetOptions = []string{"source", "netns", "vrf"}
+
+// Address families which can be used to force the IP version of a target
+const (
+	FamilyV4   = "v4"   // Only use IPv4 addresses
+	FamilyV6   = "v6"   // Only use IPv6 addresses
+	FamilyBoth = "both" // Test both IPv4 and IPv6 as separate targets
+)
+
+// Trace methods which can be used for path discovery
+const (
+	TraceICMP = "icmp" // Trace using ICMP echo requests
+	TraceUDP  = "udp"  // Trace using UDP datagrams to high ports
+)
+
+// Jitter algorithms which can be used for the `JTT` metric
+const (
+	JitterMean    = "mean"    // Mean difference between successive RTT's
+	JitterRFC3550 = "rfc3550" // Smoothed interarrival jitter from RFC 3550
+)
+
+// NewTarget will create a new Target from a target cell value. Targets without
+// a scheme such as `8.8.8.8` are ICMP targets, others such as `tcp://db01:5432`
+// use the scheme to select the probe type. The target can be given an alias
+// which is used for the column names such as `gw=10.0.0.1`, and an address
+// family prefix such as `v6:example.com`. Options can follow the address such
+// as `8.8.8.8 count=10 interval=200ms`.
+func NewTarget(val string) (Target, error) {
+	fields := strings.Fields(val)
+	if len(fields) == 0 {
+		return Target{}, errors.New("target is empty")
+	}
+
+	alias, addr := splitAlias(fields[0])
+	family, addr := splitFamily(addr)
+	if addr == "" {
+		return Target{}, errors.New("target address is empty")
+	}
+
+	newT := Target{
+		Name:    addr,
+		Scheme:  "icmp",
+		Address: addr,
+		Family:  family,
+	}
+	if alias != "" {
+		newT.Name = alias
+	}
+
+	if idx := strings.Index(addr, "://"); idx > 0 {
+		newT.Scheme = strings.ToLower(addr[:idx])
+		newT.Address = addr[idx+3:]
+	}
+
+	if err := newT.SetOptions(fields[1:]); err != nil {
+		return Target{}, err
+	}
+
+	return newT, nil
+}
+
+// SetOptions will parse and set options in the format `key=value`
+func (t *Target) SetOptions(opts []string) error {
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("option `%s` must be in the format key=value", opt)
+		}
+		if err := t.SetOption(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetOption will parse and set a single target option
+func (t *Target) SetOption(key, val string) error {
+	key = strings.ToLower(strings.TrimSpace(key))
+	val = strings.TrimSpace(val)
+
+	var err error
+	switch key {
+	case "alias":
+		if val == "" {
+			return errors.New("`alias` must not be empty")
+		}
+		t.Name = val
+	case "trace":
+		t.Trace, err = parseTrace(key, val)
+	case "count":
+		t.Count, err = parseIntRange(key, val, 1, 10000)
+	case "interval":
+		t.Interval, err = parseSeconds(key, val)
+	case "timeout":
+		t.Timeout, err = parseSeconds(key, val)
+	case "size":
+		t.Size, err = parseIntRange(key, val, 16, 65000)
+	case "maxmtu":
+		t.MaxMTU, err = parseIntRange(key, val, 68, 65535)
+	case "ttl":
+		t.TTL, err = parseIntRange(key, val, 1, 255)
+	case "dscp":
+		var classes []string
+		classes, err = parseDSCP(key, val)
+		if err == nil {
+			t.Class = strings.Join(classes, ",")
+			t.DSCP, _ = dscpValue(classes[0])
+		}
+	case "jitter":
+		t.Jitter, err = parseJitter(key, val)
+	case "family":
+		t.Family, err = parseFamily(key, val)
+	case "source":
+		t.Source, err = parseSources(key, val)
+	case "netns":
+		t.Netns, err = parseName(key, val)
+	case "vrf":
+		t.VRF, err = parseName(key, val)
+	case "expand":
+		t.Expand, err = strconv.ParseBool(val)
+		if err != nil {
+			err = fmt.Errorf("`%s` must be true or false", key)
+		}
+	default:
+		err = fmt.Errorf("unknown option `%s`", key)
+	}
+	return err
 }
 
-// BuildTargets is used to get all targets from config sheet ready for config
-func BuildTargets(row gsheets.SheetRow) []Target {
+// URL returns the target address including the scheme
+func (t Target) URL() string {
+	if t.Scheme == "icmp" {
+		return t.Address
+	}
+	return t.Scheme + "://" + t.Address
+}
+
+// DefaultMaxSweep is the maximum number of targets a subnet or range target
+// is expanded into when the host has no `MAXSWEEP`
+const DefaultMaxSweep int = 256
+
+// BuildTargets is used to get all targets from config sheet ready for config,
+// targets are returned in the order of the `TARGET_n` column numbers. Subnet
+// and range targets are expanded into a target for each address, up to the
+// max sweep limit. Targets which are not valid are returned with `Err` set.
+func BuildTargets(row gsheets.SheetRow, maxSweep int) []Target {
 	var newTargets []Target
-	for col, val := range row {
-		if strings.HasPrefix(col, "target_") {
-			newTarget := Target{
-				val.(string),
+	for _, col := range targetColumns(row) {
+		fields := strings.Fields(row[col].(string))
+		if len(fields) == 0 {
+			continue
+		}
+
+		newTarget, err := NewTarget(fields[0])
+		if err != nil {
+			alias, addr := splitAlias(fields[0])
+			if alias == "" {
+				alias = addr
+			}
+			newTargets = append(newTargets, invalidTarget(Target{Name: alias}, col, err))
+			continue
+		}
+
+		// Targets use the host defaults unless they set their own
+		for _, key := range hostTargetOptions {
+			opt, ok := row[key]
+			if !ok || strings.TrimSpace(opt.(string)) == "" {
+				continue
+			}
+			if err = newTarget.SetOption(key, opt.(string)); err != nil {
+				err = fmt.Errorf("host %s", err)
+				break
+			}
+		}
+		if err != nil {
+			newTargets = append(newTargets, invalidTarget(newTarget, col, err))
+			continue
+		}
+
+		// Paired columns share the target column number, inline options are
+		// applied last so they take priority
+		num := strings.TrimPrefix(col, "target_")
+		for _, key := range TargetOptions {
+			opt, ok := row[key+"_"+num]
+			if !ok || strings.TrimSpace(opt.(string)) == "" {
+				continue
+			}
+			if err = newTarget.SetOption(key, opt.(string)); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = newTarget.SetOptions(fields[1:])
+		}
+		if err != nil {
+			newTargets = append(newTargets, invalidTarget(newTarget, col, err))
+			continue
+		}
+
+		swept, err := newTarget.expandSweep(maxSweep)
+		if err != nil {
+			newTargets = append(newTargets, invalidTarget(newTarget, col, err))
+			continue
+		}
+		for _, sweep := range swept {
+			for _, source := range sweep.expandSources() {
+				for _, t := range source.expandClasses() {
+					newTargets = append(newTargets, t.expandFamily()...)
+				}
 			}
-			newTargets = append(newTargets, newTarget)
 		}
 	}
 	return newTargets
 }
+
+// invalidTarget will return a target which has the reason its config is not
+// valid set, so that it is reported with a status instead of being left out
+func invalidTarget(t Target, col string, err error) Target {
+	log.Error().Msgf("Error building target `%s`: %s", col, err)
+	t.Err = err
+	return t
+}
+
+// expandSweep will split an ICMP target with a subnet such as `10.0.0.0/28`
+// or range such as `10.0.0.1-10.0.0.20` into a target for each address. The
+// network and broadcast addresses of IPv4 subnets are not included. Targets
+// with an alias are named after the alias and address such as `branch_10.0.0.1`.
+func (t Target) expandSweep(limit int) ([]Target, error) {
+	if t.Scheme != "icmp" {
+		return []Target{t}, nil
+	}
+
+	var first, last net.IP
+	if _, subnet, err := net.ParseCIDR(t.Address); err == nil {
+		first, last = subnetRange(subnet)
+	} else if parts := strings.SplitN(t.Address, "-", 2); len(parts) == 2 {
+		first, last = net.ParseIP(parts[0]), net.ParseIP(parts[1])
+		if first == nil || last == nil {
+			// Host names can contain a hyphen
+			return []Target{t}, nil
+		}
+		if (first.To4() == nil) != (last.To4() == nil) || bytes.Compare(first.To16(), last.To16()) > 0 {
+			return nil, fmt.Errorf("range `%s` is not valid", t.Address)
+		}
+	} else {
+		return []Target{t}, nil
+	}
+
+	alias := t.Name != t.Address
+	targets := make([]Target, 0)
+	for ip := first; bytes.Compare(ip.To16(), last.To16()) <= 0; ip = nextIP(ip) {
+		if len(targets) == limit {
+			log.Warn().Msgf("Target `%s` has more than %d addresses, only the first %d are tested", t.Address, limit, limit)
+			break
+		}
+		sweep := t
+		sweep.Address, sweep.Name = ip.String(), ip.String()
+		if alias {
+			sweep.Name = t.Name + "_" + ip.String()
+		}
+		targets = append(targets, sweep)
+		if ip.Equal(last) {
+			break
+		}
+	}
+	return targets, nil
+}
+
+// subnetRange returns the first and last address to test in a subnet
+func subnetRange(subnet *net.IPNet) (net.IP, net.IP) {
+	first := subnet.IP.Mask(subnet.Mask)
+	last := make(net.IP, len(first))
+	for i := range first {
+		last[i] = first[i] | ^subnet.Mask[i]
+	}
+
+	ones, bits := subnet.Mask.Size()
+	if bits == 32 && bits-ones > 1 {
+		first, last = nextIP(first), prevIP(last)
+	}
+	return first, last
+}
+
+// nextIP returns the address after an address
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// prevIP returns the address before an address
+func prevIP(ip net.IP) net.IP {
+	prev := make(net.IP, len(ip))
+	copy(prev, ip)
+	for i := len(prev) - 1; i >= 0; i-- {
+		prev[i]--
+		if prev[i] != 0xff {
+			break
+		}
+	}
+	return prev
+}
+
+// expandSources will split a target with several sources into a target for
+// each source, the source is added to the names to keep the columns separate
+func (t Target) expandSources() []Target {
+	sources := strings.Split(t.Source, ",")
+	if len(sources) < 2 {
+		return []Target{t}
+	}
+	targets := make([]Target, len(sources))
+	for idx, source := range sources {
+		targets[idx] = t
+		targets[idx].Name, targets[idx].Source = t.Name+"_"+source, source
+	}
+	return targets
+}
+
+// expandClasses will split a target with several DSCP classes into a target
+// for each class, the class is added to the names such as `gw_EF` so the
+// classes can be compared
+func (t Target) expandClasses() []Target {
+	classes := strings.Split(t.Class, ",")
+	if len(classes) < 2 {
+		return []Target{t}
+	}
+	targets := make([]Target, len(classes))
+	for idx, class := range classes {
+		targets[idx] = t
+		targets[idx].Name, targets[idx].Class = t.Name+"_"+class, class
+		targets[idx].DSCP, _ = dscpValue(class)
+	}
+	return targets
+}
+
+// expandFamily will split a target testing both address families into a
+// target for each family, the family is added to the names to keep the
+// columns separate
+func (t Target) expandFamily() []Target {
+	if t.Family != FamilyBoth {
+		return []Target{t}
+	}
+	v4, v6 := t, t
+	v4.Name, v4.Family = t.Name+"_"+FamilyV4, FamilyV4
+	v6.Name, v6.Family = t.Name+"_"+FamilyV6, FamilyV6
+	return []Target{v4, v6}
+}
+
+// targetColumns will return the `TARGET_n` columns of a row sorted by number,
+// columns without a number are sorted last by name
+func targetColumns(row gsheets.SheetRow) []string {
+	cols := make([]string, 0)
+	for col := range row {
+		if strings.HasPrefix(col, "target_") {
+			cols = append(cols, col)
+		}
+	}
+
+	sort.Slice(cols, func(i, j int) bool {
+		numI, errI := strconv.Atoi(strings.TrimPrefix(cols[i], "target_"))
+		numJ, errJ := strconv.Atoi(strings.TrimPrefix(cols[j], "target_"))
+		switch {
+		case errI == nil && errJ == nil:
+			return numI < numJ
+		case errI == nil || errJ == nil:
+			return errI == nil
+		}
+		return cols[i] < cols[j]
+	})
+	return cols
+}
+
+// splitAlias will split a target in the format `alias=address`, the alias is
+// only used when the `=` is before any part of the address such as a scheme
+func splitAlias(val string) (string, string) {
+	idx := strings.Index(val, "=")
+	if idx < 0 || strings.ContainsAny(val[:idx], ":/?") {
+		return "", val
+	}
+	return val[:idx], val[idx+1:]
+}
+
+// splitFamily will split an address family prefix such as `v6:` from a
+// target address
+func splitFamily(val string) (string, string) {
+	for _, family := range []string{FamilyV4, FamilyV6, FamilyBoth} {
+		if len(val) > len(family) && strings.EqualFold(val[:len(family)+1], family+":") {
+			return family, val[len(family)+1:]
+		}
+	}
+	return "", val
+}
+
+// parseSources will parse a comma separated list of source addresses or
+// interfaces
+func parseSources(key, val string) (string, error) {
+	sources := make([]string, 0)
+	for _, source := range strings.Split(val, ",") {
+		if source = strings.TrimSpace(source); source != "" {
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		return "", fmt.Errorf("`%s` must not be empty", key)
+	}
+	return strings.Join(sources, ","), nil
+}
+
+// parseName will check the name of a namespace or device is not empty and
+// does not contain spaces
+func parseName(key, val string) (string, error) {
+	if val == "" || strings.ContainsAny(val, " \t") {
+		return "", fmt.Errorf("`%s` must be a name without spaces", key)
+	}
+	return val, nil
+}
+
+// parseFamily will check the name of an address family
+func parseFamily(key, val string) (string, error) {
+	val = strings.ToLower(val)
+	if val != FamilyV4 && val != FamilyV6 && val != FamilyBoth {
+		return "", fmt.Errorf("`%s` must be %s, %s or %s", key, FamilyV4, FamilyV6, FamilyBoth)
+	}
+	return val, nil
+}
+
+// parseIntRange will parse a number and check it is within a range
+func parseIntRange(key, val string, min, max int) (int, error) {
+	num, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("`%s` must be number", key)
+	}
+	if num < min || num > max {
+		return 0, fmt.Errorf("`%s` must be between %d and %d", key, min, max)
+	}
+	return num, nil
+}
+
+// parseJitter will check the name of a jitter algorithm
+func parseJitter(key, val string) (string, error) {
+	val = strings.ToLower(val)
+	if val != JitterMean && val != JitterRFC3550 {
+		return "", fmt.Errorf("`%s` must be %s or %s", key, JitterMean, JitterRFC3550)
+	}
+	return val, nil
+}
+
+// parseTrace will check the name of a trace method, an empty method disables
+// tracing
+func parseTrace(key, val string) (string, error) {
+	val = strings.ToLower(val)
+	if val != "" && val != TraceICMP && val != TraceUDP {
+		return "", fmt.Errorf("`%s` must be %s or %s", key, TraceICMP, TraceUDP)
+	}
+	return val, nil
+}
+
+// parseSeconds will parse a duration such as `500ms` or a number of seconds
+func parseSeconds(key, val string) (time.Duration, error) {
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		secs, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return 0, fmt.Errorf("`%s` must be a duration or number of seconds", key)
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("`%s` must be greater than zero", key)
+	}
+	return d, nil
+}
@@ -0,0 +1,367 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/gsheets"
+)
+
+// targetNames returns the names of targets
+func targetNames(targets []Target) []string {
+	names := make([]string, 0, len(targets))
+	for _, t := range targets {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+func TestExpandSweep(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		limit   int
+		want    []string
+		wantErr bool
+	}{
+		{"host", "10.0.0.1", 10, []string{"10.0.0.1"}, false},
+		{"hostname with hyphen", "db-01.example.com", 10, []string{"db-01.example.com"}, false},
+		{"subnet skips network and broadcast", "10.0.0.0/30", 10, []string{"10.0.0.1", "10.0.0.2"}, false},
+		{"point to point subnet", "10.0.0.0/31", 10, []string{"10.0.0.0", "10.0.0.1"}, false},
+		{"host subnet", "10.0.0.5/32", 10, []string{"10.0.0.5"}, false},
+		{"ipv6 subnet", "2001:db8::/127", 10, []string{"2001:db8::", "2001:db8::1"}, false},
+		{"range", "10.0.0.254-10.0.1.1", 10, []string{"10.0.0.254", "10.0.0.255", "10.0.1.0", "10.0.1.1"}, false},
+		{"single address range", "10.0.0.1-10.0.0.1", 10, []string{"10.0.0.1"}, false},
+		{"range is capped", "10.0.0.1-10.0.0.20", 3, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, false},
+		{"subnet is capped", "10.0.0.0/24", 2, []string{"10.0.0.1", "10.0.0.2"}, false},
+		{"alias", "branch=10.0.0.0/30", 10, []string{"branch_10.0.0.1", "branch_10.0.0.2"}, false},
+		{"reversed range", "10.0.0.9-10.0.0.1", 10, nil, true},
+		{"mixed family range", "10.0.0.1-2001:db8::1", 10, nil, true},
+		{"tcp target is not swept", "tcp://10.0.0.0/30", 10, []string{"tcp://10.0.0.0/30"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := NewTarget(tt.target)
+			if err != nil {
+				t.Fatalf("NewTarget() error = %v", err)
+			}
+			got, err := target.expandSweep(tt.limit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandSweep() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(targetNames(got), tt.want) {
+				t.Errorf("expandSweep() = %v, want %v", targetNames(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildTargetsMaxSweep(t *testing.T) {
+	row := gsheets.SheetRow{
+		"target_1": "10.0.0.0/24",
+		"target_2": "10.0.1.1",
+	}
+	tests := []struct {
+		name     string
+		maxSweep int
+		want     int
+	}{
+		{"default", DefaultMaxSweep, 255},
+		{"capped", 4, 5},
+		{"one", 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildTargets(row, tt.maxSweep)
+			if len(got) != tt.want {
+				t.Fatalf("BuildTargets() returned %d targets, want %d", len(got), tt.want)
+			}
+			if last := got[len(got)-1].Name; last != "10.0.1.1" {
+				t.Errorf("BuildTargets() last target = %s, want 10.0.1.1", last)
+			}
+		})
+	}
+}
+
+func TestSetOptionTrace(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    string
+		wantErr bool
+	}{
+		{"icmp", TraceICMP, false},
+		{"UDP", TraceUDP, false},
+		{"", "", false},
+		{"yes", "", true},
+		{"tcp", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.val, func(t *testing.T) {
+			var target Target
+			err := target.SetOption("trace", tt.val)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetOption() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if target.Trace != tt.want {
+				t.Errorf("SetOption() trace = %s, want %s", target.Trace, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildTargetsOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		row  gsheets.SheetRow
+		want Target
+	}{
+		{
+			name: "inline",
+			row:  gsheets.SheetRow{"target_1": "10.0.0.1 count=10 interval=200ms"},
+			want: Target{Name: "10.0.0.1", Scheme: "icmp", Address: "10.0.0.1", Count: 10, Interval: 200 * time.Millisecond},
+		},
+		{
+			name: "paired column",
+			row:  gsheets.SheetRow{"target_1": "10.0.0.1", "count_1": "5", "size_1": "1000"},
+			want: Target{Name: "10.0.0.1", Scheme: "icmp", Address: "10.0.0.1", Count: 5, Size: 1000},
+		},
+		{
+			name: "paired column of another target",
+			row:  gsheets.SheetRow{"target_1": "10.0.0.1", "count_2": "5"},
+			want: Target{Name: "10.0.0.1", Scheme: "icmp", Address: "10.0.0.1"},
+		},
+		{
+			name: "inline takes priority over paired column",
+			row:  gsheets.SheetRow{"target_1": "10.0.0.1 count=10 ttl=8", "count_1": "5", "timeout_1": "2"},
+			want: Target{Name: "10.0.0.1", Scheme: "icmp", Address: "10.0.0.1", Count: 10, TTL: 8, Timeout: 2 * time.Second},
+		},
+		{
+			name: "paired alias column",
+			row:  gsheets.SheetRow{"target_1": "10.0.0.1", "alias_1": "gw"},
+			want: Target{Name: "gw", Scheme: "icmp", Address: "10.0.0.1"},
+		},
+		{
+			name: "inline alias takes priority over paired column",
+			row:  gsheets.SheetRow{"target_1": "10.0.0.1 alias=core", "alias_1": "gw"},
+			want: Target{Name: "core", Scheme: "icmp", Address: "10.0.0.1"},
+		},
+		{
+			name: "mtu paired column",
+			row:  gsheets.SheetRow{"target_1": "mtu://10.0.0.1", "maxmtu_1": "9000"},
+			want: Target{Name: "mtu://10.0.0.1", Scheme: "mtu", Address: "10.0.0.1", MaxMTU: 9000},
+		},
+		{
+			name: "empty paired column",
+			row:  gsheets.SheetRow{"target_1": "10.0.0.1", "count_1": " "},
+			want: Target{Name: "10.0.0.1", Scheme: "icmp", Address: "10.0.0.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildTargets(tt.row, DefaultMaxSweep)
+			if len(got) != 1 {
+				t.Fatalf("BuildTargets() returned %d targets, want 1", len(got))
+			}
+			if !reflect.DeepEqual(got[0], tt.want) {
+				t.Errorf("BuildTargets() = %+v, want %+v", got[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildTargetsInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		row      gsheets.SheetRow
+		wantName string
+	}{
+		{"unknown option", gsheets.SheetRow{"target_1": "10.0.0.1 colour=red"}, "10.0.0.1"},
+		{"option without value", gsheets.SheetRow{"target_1": "10.0.0.1 count"}, "10.0.0.1"},
+		{"count too low", gsheets.SheetRow{"target_1": "10.0.0.1 count=0"}, "10.0.0.1"},
+		{"ttl too high", gsheets.SheetRow{"target_1": "10.0.0.1 ttl=256"}, "10.0.0.1"},
+		{"size not a number", gsheets.SheetRow{"target_1": "10.0.0.1 size=big"}, "10.0.0.1"},
+		{"interval not a duration", gsheets.SheetRow{"target_1": "10.0.0.1 interval=soon"}, "10.0.0.1"},
+		{"invalid paired column", gsheets.SheetRow{"target_1": "10.0.0.1", "ttl_1": "0"}, "10.0.0.1"},
+		{"maxmtu too low", gsheets.SheetRow{"target_1": "mtu://10.0.0.1 maxmtu=60"}, "mtu://10.0.0.1"},
+		{"maxmtu too high", gsheets.SheetRow{"target_1": "mtu://10.0.0.1", "maxmtu_1": "65536"}, "mtu://10.0.0.1"},
+		{"unknown dscp class", gsheets.SheetRow{"target_1": "10.0.0.1 dscp=AF51"}, "10.0.0.1"},
+		{"empty address with alias", gsheets.SheetRow{"target_1": "gw="}, "gw"},
+		{"invalid target with paired alias", gsheets.SheetRow{"target_1": "gw=10.0.0.1", "ttl_1": "0"}, "gw"},
+		{"unknown family", gsheets.SheetRow{"target_1": "example.com family=v5"}, "example.com"},
+		{"empty source list", gsheets.SheetRow{"target_1": "8.8.8.8 source=,"}, "8.8.8.8"},
+		{"unknown trace method", gsheets.SheetRow{"target_1": "10.0.0.1 trace=yes"}, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildTargets(tt.row, DefaultMaxSweep)
+			if len(got) != 1 {
+				t.Fatalf("BuildTargets() returned %d targets, want 1", len(got))
+			}
+			if got[0].Err == nil {
+				t.Errorf("BuildTargets() target %+v has no error", got[0])
+			}
+			if got[0].Name != tt.wantName {
+				t.Errorf("BuildTargets() name = %s, want %s", got[0].Name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestNewTargetAlias(t *testing.T) {
+	tests := []struct {
+		val         string
+		wantName    string
+		wantScheme  string
+		wantAddress string
+		wantErr     bool
+	}{
+		{"10.0.0.1", "10.0.0.1", "icmp", "10.0.0.1", false},
+		{"gw=10.0.0.1", "gw", "icmp", "10.0.0.1", false},
+		{"10.0.0.1 alias=gw", "gw", "icmp", "10.0.0.1", false},
+		{"db=tcp://db01:5432", "db", "tcp", "db01:5432", false},
+		{"https://example.com/?a=b", "https://example.com/?a=b", "https", "example.com/?a=b", false},
+		{"gw=", "", "", "", true},
+		{"10.0.0.1 alias=", "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.val, func(t *testing.T) {
+			got, err := NewTarget(tt.val)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Name != tt.wantName || got.Scheme != tt.wantScheme || got.Address != tt.wantAddress {
+				t.Errorf("NewTarget() = %s %s %s, want %s %s %s",
+					got.Name, got.Scheme, got.Address, tt.wantName, tt.wantScheme, tt.wantAddress)
+			}
+		})
+	}
+}
+
+func TestBuildTargetsFamily(t *testing.T) {
+	tests := []struct {
+		name         string
+		row          gsheets.SheetRow
+		wantNames    []string
+		wantFamilies []string
+	}{
+		{"no family", gsheets.SheetRow{"target_1": "example.com"}, []string{"example.com"}, []string{""}},
+		{"prefix", gsheets.SheetRow{"target_1": "v6:example.com"}, []string{"example.com"}, []string{FamilyV6}},
+		{"option", gsheets.SheetRow{"target_1": "example.com family=v4"}, []string{"example.com"}, []string{FamilyV4}},
+		{"paired column", gsheets.SheetRow{"target_1": "example.com", "family_1": "V6"}, []string{"example.com"}, []string{FamilyV6}},
+		{
+			name:         "both",
+			row:          gsheets.SheetRow{"target_1": "both:example.com"},
+			wantNames:    []string{"example.com_v4", "example.com_v6"},
+			wantFamilies: []string{FamilyV4, FamilyV6},
+		},
+		{
+			name:         "both with alias",
+			row:          gsheets.SheetRow{"target_1": "web=tcp://example.com:443 family=both"},
+			wantNames:    []string{"web_v4", "web_v6"},
+			wantFamilies: []string{FamilyV4, FamilyV6},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildTargets(tt.row, DefaultMaxSweep)
+			if names := targetNames(got); !reflect.DeepEqual(names, tt.wantNames) {
+				t.Fatalf("BuildTargets() = %v, want %v", names, tt.wantNames)
+			}
+			for i, target := range got {
+				if target.Err != nil {
+					t.Errorf("BuildTargets() target %s error = %v", target.Name, target.Err)
+				}
+				if target.Family != tt.wantFamilies[i] {
+					t.Errorf("BuildTargets() target %s family = %s, want %s", target.Name, target.Family, tt.wantFamilies[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBuildTargetsSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		row         gsheets.SheetRow
+		wantNames   []string
+		wantSources []string
+	}{
+		{"no source", gsheets.SheetRow{"target_1": "8.8.8.8"}, []string{"8.8.8.8"}, []string{""}},
+		{"host default", gsheets.SheetRow{"target_1": "8.8.8.8", "source": "wan1"}, []string{"8.8.8.8"}, []string{"wan1"}},
+		{
+			name:        "paired column takes priority over host",
+			row:         gsheets.SheetRow{"target_1": "8.8.8.8", "source": "wan1", "source_1": "192.0.2.10"},
+			wantNames:   []string{"8.8.8.8"},
+			wantSources: []string{"192.0.2.10"},
+		},
+		{
+			name:        "inline takes priority over paired column",
+			row:         gsheets.SheetRow{"target_1": "8.8.8.8 source=wan2", "source_1": "wan1"},
+			wantNames:   []string{"8.8.8.8"},
+			wantSources: []string{"wan2"},
+		},
+		{
+			name:        "list",
+			row:         gsheets.SheetRow{"target_1": "8.8.8.8 source=wan1,wan2"},
+			wantNames:   []string{"8.8.8.8_wan1", "8.8.8.8_wan2"},
+			wantSources: []string{"wan1", "wan2"},
+		},
+		{
+			name:        "host list with alias",
+			row:         gsheets.SheetRow{"target_1": "dns=8.8.8.8", "source": "wan1, ,wan2"},
+			wantNames:   []string{"dns_wan1", "dns_wan2"},
+			wantSources: []string{"wan1", "wan2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildTargets(tt.row, DefaultMaxSweep)
+			if names := targetNames(got); !reflect.DeepEqual(names, tt.wantNames) {
+				t.Fatalf("BuildTargets() = %v, want %v", names, tt.wantNames)
+			}
+			for i, target := range got {
+				if target.Err != nil {
+					t.Errorf("BuildTargets() target %s error = %v", target.Name, target.Err)
+				}
+				if target.Source != tt.wantSources[i] {
+					t.Errorf("BuildTargets() target %s source = %s, want %s", target.Name, target.Source, tt.wantSources[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBuildTargetsNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		row       gsheets.SheetRow
+		wantNetns string
+		wantVRF   string
+		wantErr   bool
+	}{
+		{"none", gsheets.SheetRow{"target_1": "10.0.0.1"}, "", "", false},
+		{"host defaults", gsheets.SheetRow{"target_1": "10.0.0.1", "netns": "red", "vrf": "mgmt"}, "red", "mgmt", false},
+		{"paired column takes priority over host", gsheets.SheetRow{"target_1": "10.0.0.1", "netns": "red", "netns_1": "blue"}, "blue", "", false},
+		{"inline", gsheets.SheetRow{"target_1": "10.0.0.1 netns=blue vrf=wan", "vrf": "mgmt"}, "blue", "wan", false},
+		{"invalid host option", gsheets.SheetRow{"target_1": "10.0.0.1", "vrf": "vrf red"}, "", "", true},
+		{"invalid inline option", gsheets.SheetRow{"target_1": "10.0.0.1 netns="}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildTargets(tt.row, DefaultMaxSweep)
+			if len(got) != 1 {
+				t.Fatalf("BuildTargets() returned %d targets, want 1", len(got))
+			}
+			if (got[0].Err != nil) != tt.wantErr {
+				t.Fatalf("BuildTargets() error = %v, wantErr %v", got[0].Err, tt.wantErr)
+			}
+			if got[0].Netns != tt.wantNetns || got[0].VRF != tt.wantVRF {
+				t.Errorf("BuildTargets() netns, vrf = %s, %s, want %s, %s", got[0].Netns, got[0].VRF, tt.wantNetns, tt.wantVRF)
+			}
+		})
+	}
+}
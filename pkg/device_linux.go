@@ -0,0 +1,18 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import "syscall"
+
+// bindDevice will bind a socket to an interface so probes always leave through
+// the interface, regardless of the routing table
+func bindDevice(fd uintptr, iface string) error {
+	return syscall.BindToDevice(int(fd), iface)
+}
+
+// bindVRF will bind a socket to a VRF device so probes use the routing table
+// of the VRF
+func bindVRF(fd uintptr, vrf string) error {
+	return syscall.BindToDevice(int(fd), vrf)
+}
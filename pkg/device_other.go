@@ -0,0 +1,20 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+//go:build !linux
+// +build !linux
+
+package ping
+
+import "errors"
+
+// bindDevice does nothing on platforms which cannot bind a socket to an
+// interface, the socket is only bound to the interface address
+func bindDevice(fd uintptr, iface string) error {
+	return nil
+}
+
+// bindVRF will return an error as VRFs are only supported on Linux
+func bindVRF(fd uintptr, vrf string) error {
+	return errors.New("VRFs are only supported on Linux")
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var dnsTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+var dnsRcodes = map[dnsmessage.RCode]string{
+	dnsmessage.RCodeSuccess:        "NOERROR",
+	dnsmessage.RCodeFormatError:    "FORMERR",
+	dnsmessage.RCodeServerFailure:  "SERVFAIL",
+	dnsmessage.RCodeNameError:      "NXDOMAIN",
+	dnsmessage.RCodeNotImplemented: "NOTIMP",
+	dnsmessage.RCodeRefused:        "REFUSED",
+}
+
+// dnsQuery holds a parsed DNS target
+type dnsQuery struct {
+	network  string
+	resolver string
+	question dnsmessage.Question
+	ip       string // Resolver address of the last exchange
+}
+
+func init() {
+	Register("dns", dnsProber{})
+}
+
+// dnsProber tests targets by sending queries to a resolver, the time taken for
+// the resolver to respond to the query is used as the RTT
+type dnsProber struct{}
+
+// Probe will run a single DNS test to a target
+func (dnsProber) Probe(ctx context.Context, t config.Target, opts Options) (Result, error) {
+	query, err := parseDNSTarget(t.URL())
+	if err != nil {
+		return Result{}, err
+	}
+
+	query.network = targetNetwork(t, "udp")
+	query.resolver = dialAddress(t, query.resolver)
+	dialer := newDialer(t)
+	answers, rcode := 0, ""
+	samples := Series(ctx, t, func(seq int) (time.Duration, error) {
+		rtt, header, n, err := query.exchange(ctx, dialer)
+		if err != nil {
+			log.Debug().Msgf("DNS query to `%s` failed: %s", t.Name, err)
+			return 0, err
+		}
+		answers, rcode = n, rcodeName(header.RCode)
+		return rtt, nil
+	})
+
+	result := NewResult(t, samples)
+	result.Extra = map[string]interface{}{"ANSWERS": answers, "RCODE": rcode}
+	result.IP = query.ip
+	return result, nil
+}
+
+// Metrics returns the answer count and response code metrics
+func (dnsProber) Metrics(t config.Target) []string {
+	return []string{"ANSWERS", "RCODE"}
+}
+
+// parseDNSTarget will parse a target in the format
+// `dns://resolver[:port]/name?type=A` into a query
+func parseDNSTarget(target string) (*dnsQuery, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Hostname() == "" {
+		return nil, errors.New("resolver is missing")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "53"
+	}
+
+	name := strings.Trim(u.Path, "/")
+	if name == "" {
+		return nil, errors.New("query name is missing")
+	}
+	qname, err := dnsmessage.NewName(name + ".")
+	if err != nil {
+		return nil, err
+	}
+
+	qtype := dnsmessage.TypeA
+	if val := u.Query().Get("type"); val != "" {
+		var ok bool
+		qtype, ok = dnsTypes[strings.ToUpper(val)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported query type `%s`", val)
+		}
+	}
+
+	return &dnsQuery{
+		network:  "udp",
+		resolver: net.JoinHostPort(u.Hostname(), port),
+		question: dnsmessage.Question{
+			Name:  qname,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		},
+	}, nil
+}
+
+// exchange will send the query to the resolver and return the time taken to
+// receive the response along with its header and answer count
+func (q *dnsQuery) exchange(ctx context.Context, dialer targetDialer) (time.Duration, dnsmessage.Header, int, error) {
+	b := make([]byte, 2)
+	if _, err := rand.Read(b); err != nil {
+		return 0, dnsmessage.Header{}, 0, err
+	}
+	id := binary.BigEndian.Uint16(b)
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               id,
+			RecursionDesired: true,
+		},
+		Questions: []dnsmessage.Question{q.question},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return 0, dnsmessage.Header{}, 0, err
+	}
+
+	conn, err := dialer.DialContext(ctx, q.network, q.resolver)
+	if err != nil {
+		return 0, dnsmessage.Header{}, 0, err
+	}
+	defer conn.Close()
+	q.ip = remoteIP(conn)
+
+	start := time.Now()
+	deadline := start.Add(dialer.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(packed); err != nil {
+		return 0, dnsmessage.Header{}, 0, err
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, dnsmessage.Header{}, 0, err
+		}
+		rtt := time.Since(start)
+
+		var p dnsmessage.Parser
+		header, err := p.Start(buf[:n])
+		if err != nil || header.ID != id || !header.Response {
+			// Ignore anything which is not a response to our query
+			continue
+		}
+		if err := p.SkipAllQuestions(); err != nil {
+			return 0, dnsmessage.Header{}, 0, err
+		}
+		answers, err := p.AllAnswers()
+		if err != nil {
+			return 0, dnsmessage.Header{}, 0, err
+		}
+		return rtt, header, len(answers), nil
+	}
+}
+
+// rcodeName returns the conventional name for a DNS response code
+func rcodeName(rcode dnsmessage.RCode) string {
+	if name, ok := dnsRcodes[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// icmpConn is an ICMP socket which can be shared by many pingers
+type icmpConn interface {
+	WriteTo(b []byte, dst net.Addr) (int, error)
+	Close() error
+
+	// readICMP will read a single ICMP message and return the time it was
+	// received, using the kernel receive timestamp where it is supported
+	readICMP(b []byte) (int, time.Time, error)
+}
+
+// replyHandler receives the replies and errors for the echo requests sent by
+// a single pinger
+type replyHandler interface {
+	reply(seq int, rtt time.Duration)
+	unreachable(seq int)
+}
+
+// socketKey identifies a shared socket, pingers using different socket
+// options cannot share a socket
+type socketKey struct {
+	ipv4       bool
+	privileged bool
+	ttl        int
+	tos        int
+	source     string
+	netns      string
+	vrf        string
+	df         bool
+}
+
+// icmpEngine multiplexes the echo requests of all pingers over one socket for
+// each address family and set of socket options. Replies are matched to the
+// pinger using the tracker in the echo payload, and for privileged sockets
+// also the echo ID.
+type icmpEngine struct {
+	mu      sync.Mutex
+	sockets map[socketKey]*engineSocket
+}
+
+// engine is shared by all ICMP probes
+var engine = &icmpEngine{
+	sockets: make(map[socketKey]*engineSocket),
+}
+
+// engineSocket holds a shared socket and the pingers using it
+type engineSocket struct {
+	key  socketKey
+	conn icmpConn
+
+	mu       sync.RWMutex
+	pingers  map[uint64]*icmpPinger // Keyed by tracker
+	handlers map[uint64]replyHandler
+	byID     map[int]uint64 // Trackers keyed by echo ID for privileged sockets
+}
+
+// register will add a pinger to the socket for its options and return the
+// socket to send with, the socket is opened when it is first used
+func (e *icmpEngine) register(p *icmpPinger, h replyHandler) (*engineSocket, error) {
+	key := socketKey{
+		ipv4:       p.ipv4,
+		privileged: p.privileged,
+		ttl:        p.ttl,
+		tos:        p.tos,
+		source:     p.source,
+		netns:      p.netns,
+		vrf:        p.vrf,
+		df:         p.df,
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, ok := e.sockets[key]
+	if !ok {
+		conn, err := listenICMP(key)
+		if err != nil {
+			return nil, err
+		}
+		s = &engineSocket{
+			key:      key,
+			conn:     conn,
+			pingers:  make(map[uint64]*icmpPinger),
+			handlers: make(map[uint64]replyHandler),
+			byID:     make(map[int]uint64),
+		}
+		e.sockets[key] = s
+		log.Debug().Msgf("Opened shared ICMP socket %+v", key)
+		go s.readLoop()
+	}
+
+	s.mu.Lock()
+	s.pingers[p.tracker] = p
+	s.handlers[p.tracker] = h
+	if p.privileged {
+		s.byID[p.id] = p.tracker
+	}
+	s.mu.Unlock()
+	return s, nil
+}
+
+// unregister will remove a pinger from its socket, the socket is closed when
+// it has no pingers left
+func (e *icmpEngine) unregister(s *engineSocket, p *icmpPinger) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s.mu.Lock()
+	delete(s.pingers, p.tracker)
+	delete(s.handlers, p.tracker)
+	if s.byID[p.id] == p.tracker {
+		delete(s.byID, p.id)
+	}
+	empty := len(s.pingers) == 0
+	s.mu.Unlock()
+
+	if empty {
+		delete(e.sockets, s.key)
+		s.conn.Close()
+		log.Debug().Msgf("Closed shared ICMP socket %+v", s.key)
+	}
+}
+
+// readLoop will read messages and pass them to the pinger they belong to
+// until the socket is closed
+func (s *engineSocket) readLoop() {
+	proto := 1 // ICMP
+	if !s.key.ipv4 {
+		proto = 58 // ICMPv6
+	}
+
+	buf := make([]byte, 65536)
+	for {
+		n, received, err := s.conn.readICMP(buf)
+		if err != nil {
+			// Socket has been closed
+			return
+		}
+
+		msg, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+
+		switch msg.Type {
+		case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply:
+			echo, ok := msg.Body.(*icmp.Echo)
+			if !ok || len(echo.Data) < icmpHeaderLen {
+				continue
+			}
+
+			tracker := binary.BigEndian.Uint64(echo.Data[8:16])
+			s.mu.RLock()
+			p, h := s.pingers[tracker], s.handlers[tracker]
+			s.mu.RUnlock()
+
+			// Datagram sockets have the ID set by the kernel
+			if p == nil || p.privileged && echo.ID != p.id {
+				continue
+			}
+			sentAt := time.Unix(0, int64(binary.BigEndian.Uint64(echo.Data[:8])))
+			h.reply(echo.Seq, received.Sub(sentAt))
+		case ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable, ipv6.ICMPTypePacketTooBig:
+			var data []byte
+			switch body := msg.Body.(type) {
+			case *icmp.DstUnreach:
+				data = body.Data
+			case *icmp.PacketTooBig:
+				data = body.Data
+			default:
+				continue
+			}
+			dst, id, seq, ok := parseUnreachable(s.key.ipv4, data)
+			if !ok {
+				continue
+			}
+
+			s.mu.RLock()
+			tracker, ok := s.byID[id]
+			p, h := s.pingers[tracker], s.handlers[tracker]
+			s.mu.RUnlock()
+
+			if !ok || p == nil || !dst.Equal(p.dst.IP) {
+				continue
+			}
+			h.unreachable(seq)
+		}
+	}
+}
+
+// parseUnreachable will return the destination, ID and sequence of the echo
+// request which is included in an unreachable error
+func parseUnreachable(isIPv4 bool, data []byte) (net.IP, int, int, bool) {
+	var dst net.IP
+	var echo []byte
+	if isIPv4 {
+		if len(data) < ipv4.HeaderLen {
+			return nil, 0, 0, false
+		}
+		hdrLen := int(data[0]&0x0f) << 2
+		if len(data) < hdrLen+8 || data[9] != 1 {
+			return nil, 0, 0, false
+		}
+		dst, echo = net.IP(data[16:20]), data[hdrLen:]
+		if echo[0] != byte(ipv4.ICMPTypeEcho) {
+			return nil, 0, 0, false
+		}
+	} else {
+		if len(data) < ipv6.HeaderLen+8 || data[6] != 58 {
+			return nil, 0, 0, false
+		}
+		dst, echo = net.IP(data[24:40]), data[ipv6.HeaderLen:]
+		if echo[0] != byte(ipv6.ICMPTypeEchoRequest) {
+			return nil, 0, 0, false
+		}
+	}
+
+	id := int(binary.BigEndian.Uint16(echo[4:6]))
+	seq := int(binary.BigEndian.Uint16(echo[6:8]))
+	return dst, id, seq, true
+}
@@ -196,6 +196,20 @@ func AddRow(svc *sheets.Service, sheetID, worksheet string, row []interface{}) e
 	return nil
 }
 
+// AddRows will add multiple slices of values as new rows
+func AddRows(svc *sheets.Service, sheetID, worksheet string, rows [][]interface{}) error {
+	valRange := &sheets.ValueRange{}
+	valRange.MajorDimension = "ROWS"
+	valRange.Values = rows
+
+	_, err := svc.Spreadsheets.Values.Append(sheetID, worksheet, valRange).ValueInputOption("USER_ENTERED").Do()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // DeleteLastRows will delete the oldest X rows based on count value
 func DeleteLastRows(svc *sheets.Service, sheetID string, worksheetID int64, count int64) error {
 	req := sheets.Request{
@@ -0,0 +1,155 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+// httpTiming holds the phase durations for a single HTTP request
+type httpTiming struct {
+	dns     time.Duration
+	connect time.Duration
+	tls     time.Duration
+	ttfb    time.Duration
+	total   time.Duration
+	ip      string
+}
+
+func init() {
+	Register("http", httpProber{})
+	Register("https", httpProber{})
+}
+
+// httpProber tests targets using HTTP GET requests, a new connection is made
+// for each request so that every phase is measured
+type httpProber struct{}
+
+// Probe will run a single HTTP test to a target
+func (httpProber) Probe(ctx context.Context, t config.Target, opts Options) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL(), nil)
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("User-Agent", "pingsheet")
+
+	dialer := newDialer(t)
+	client := &http.Client{
+		Timeout: t.Timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, targetNetwork(t, network), dialAddress(t, addr))
+			},
+			DisableKeepAlives: true,
+		},
+		// Only time the request to the target itself
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	timings := make([]httpTiming, 0, t.Count)
+	code, ip := 0, ""
+	samples := Series(ctx, t, func(seq int) (time.Duration, error) {
+		timing, status, err := httpRequest(client, req)
+		if err != nil {
+			log.Debug().Msgf("HTTP request to `%s` failed: %s", t.Name, err)
+			return 0, err
+		}
+		timings = append(timings, timing)
+		code, ip = status, timing.ip
+		return timing.total, nil
+	})
+
+	// Report the average time in milliseconds spent in each phase
+	var dnsMs, connectMs, tlsMs, ttfbMs float64
+	for _, timing := range timings {
+		dnsMs += durationToMs(timing.dns)
+		connectMs += durationToMs(timing.connect)
+		tlsMs += durationToMs(timing.tls)
+		ttfbMs += durationToMs(timing.ttfb)
+	}
+	if n := float64(len(timings)); n > 0 {
+		dnsMs, connectMs, tlsMs, ttfbMs = dnsMs/n, connectMs/n, tlsMs/n, ttfbMs/n
+	}
+
+	result := NewResult(t, samples)
+	result.Extra = map[string]interface{}{
+		"DNS":     dnsMs,
+		"CONNECT": connectMs,
+		"TLS":     tlsMs,
+		"TTFB":    ttfbMs,
+		"TOTAL":   result.RTT,
+		"CODE":    code,
+	}
+	result.IP = ip
+	return result, nil
+}
+
+// Metrics returns the request phase and status code metrics
+func (httpProber) Metrics(t config.Target) []string {
+	return []string{"DNS", "CONNECT", "TLS", "TTFB", "TOTAL", "CODE"}
+}
+
+// httpRequest will perform a single request and return the phase timings and
+// the status code of the response
+func httpRequest(client *http.Client, req *http.Request) (httpTiming, int, error) {
+	var timing httpTiming
+	var dnsStart, connectStart, tlsStart time.Time
+
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			timing.dns = time.Since(dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			timing.connect = time.Since(connectStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			timing.ip = remoteIP(info.Conn)
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			timing.tls = time.Since(tlsStart)
+		},
+	}
+
+	start := time.Now()
+	trace.GotFirstResponseByte = func() {
+		timing.ttfb = time.Since(start)
+	}
+
+	resp, err := client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		return timing, 0, err
+	}
+	defer resp.Body.Close()
+
+	// Include the time to read the body in the total
+	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+		return timing, 0, err
+	}
+	timing.total = time.Since(start)
+
+	return timing, resp.StatusCode, nil
+}
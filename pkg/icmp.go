@@ -0,0 +1,307 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	icmpHeaderLen int = 16 // Timestamp and tracker added to each echo payload
+	icmpMinSize   int = icmpHeaderLen
+)
+
+func init() {
+	Register("icmp", icmpProber{})
+}
+
+// icmpProber tests targets using ICMP echo requests
+type icmpProber struct{}
+
+// Probe will run a single ICMP test to a target
+func (icmpProber) Probe(ctx context.Context, t config.Target, opts Options) (Result, error) {
+	pinger, err := newTargetPinger(t, opts.Privileged)
+	if err != nil {
+		return Result{}, err
+	}
+	if t.Size > 0 {
+		pinger.size = t.Size
+	}
+
+	samples, err := pinger.run(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	result := NewResult(t, samples)
+	result.IP = pinger.dst.IP.String()
+	return result, nil
+}
+
+// Metrics returns no additional metrics for ICMP targets
+func (icmpProber) Metrics(t config.Target) []string {
+	return nil
+}
+
+// icmpAddress returns the address to ping for a target, the host of the
+// address is used for targets of other schemes such as `mtu://10.0.0.1`
+func icmpAddress(t config.Target) string {
+	if t.IP != "" {
+		return t.IP
+	}
+	if host, err := targetHost(t); err == nil {
+		return host
+	}
+	return t.Address
+}
+
+// newTargetPinger is used to create a new icmpPinger for a target with the
+// target options applied, except for the size
+func newTargetPinger(t config.Target, privileged bool) (*icmpPinger, error) {
+	pinger, err := newICMPPinger(icmpAddress(t), targetNetwork(t, "ip"), privileged)
+	if err != nil {
+		return nil, err
+	}
+	pinger.count = t.Count
+	pinger.interval = t.Interval
+	pinger.timeout = t.Timeout
+	pinger.ttl = t.TTL
+	pinger.tos = t.DSCP << 2
+	pinger.source = t.Source
+	pinger.netns = t.Netns
+	pinger.vrf = t.VRF
+	return pinger, nil
+}
+
+// pingerCount is used to give each pinger a unique ID and tracker
+var pingerCount uint32
+
+// icmpPinger sends ICMP echo requests to a single address and collects the
+// replies. Privileged pingers use raw sockets, others use datagram sockets,
+// the sockets are shared with other pingers by the engine.
+type icmpPinger struct {
+	dst        *net.IPAddr
+	ipv4       bool
+	privileged bool
+	id         int
+	tracker    uint64
+
+	count    int
+	interval time.Duration
+	timeout  time.Duration
+	size     int
+	ttl      int
+	tos      int
+	source   string
+	netns    string
+	vrf      string
+	df       bool // Set the don't fragment flag
+}
+
+// newICMPPinger is used to create a new icmpPinger for an address, the network
+// can be `ip4` or `ip6` to only resolve addresses of that family
+func newICMPPinger(addr, network string, privileged bool) (*icmpPinger, error) {
+	dst, err := net.ResolveIPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	id, tracker := newPingerID()
+	return &icmpPinger{
+		dst:        dst,
+		ipv4:       dst.IP.To4() != nil,
+		privileged: privileged,
+		id:         id,
+		tracker:    tracker,
+		count:      1,
+		interval:   time.Second,
+		timeout:    time.Second,
+		size:       icmpMinSize,
+	}, nil
+}
+
+// newPingerID returns a unique echo ID and tracker for a pinger
+func newPingerID() (int, uint64) {
+	num := atomic.AddUint32(&pingerCount, 1)
+	return (os.Getpid() + int(num)) & 0xffff, uint64(time.Now().UnixNano()) ^ uint64(num)
+}
+
+// clone will return a copy of a pinger with its own ID and tracker, replies
+// to requests sent by the original are not counted by the copy
+func (p *icmpPinger) clone() *icmpPinger {
+	c := *p
+	c.id, c.tracker = newPingerID()
+	return &c
+}
+
+// run will send all echo requests and wait for the replies, the samples are
+// returned in the order that the requests were sent. The run finishes when all
+// replies are received, the timeout has passed since the last request or the
+// context is done.
+func (p *icmpPinger) run(ctx context.Context) ([]Sample, error) {
+	r := newBurstReplies(p.count)
+	sock, err := engine.register(p, r)
+	if err != nil {
+		return nil, err
+	}
+
+	sent := 0
+	for seq := 0; seq < p.count; seq++ {
+		if seq > 0 && !sleep(ctx, p.interval) {
+			break
+		}
+		if err := p.send(sock.conn, seq); err != nil {
+			engine.unregister(sock, p)
+			return nil, err
+		}
+		sent++
+	}
+
+	timeout := time.NewTimer(p.timeout)
+	select {
+	case <-r.allRecv:
+	case <-timeout.C:
+	case <-ctx.Done():
+	}
+	timeout.Stop()
+	engine.unregister(sock, p)
+	return r.samples(sent), nil
+}
+
+// send will send a single echo request with the send time in the payload
+func (p *icmpPinger) send(conn icmpConn, seq int) error {
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if !p.ipv4 {
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+
+	data := make([]byte, icmpHeaderLen)
+	binary.BigEndian.PutUint64(data[:8], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint64(data[8:], p.tracker)
+	if p.size > icmpHeaderLen {
+		data = append(data, bytes.Repeat([]byte{1}, p.size-icmpHeaderLen)...)
+	}
+
+	msg := icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{
+			ID:   p.id,
+			Seq:  seq,
+			Data: data,
+		},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+
+	var dst net.Addr = p.dst
+	if !p.privileged {
+		dst = &net.UDPAddr{IP: p.dst.IP, Zone: p.dst.Zone}
+	}
+	_, err = conn.WriteTo(b, dst)
+	return err
+}
+
+// burstReplies holds the RTT of the first reply to each sequence, the number
+// of duplicate replies, the sequences which were received after a later
+// sequence and the sequences which were reported as unreachable
+type burstReplies struct {
+	count   int
+	allRecv chan struct{} // Closed once every sequence has an outcome
+
+	mu           sync.Mutex
+	rtts         map[int]time.Duration
+	dups         map[int]int
+	reordered    map[int]bool
+	unreachables map[int]bool
+	maxSeq       int
+	done         bool
+}
+
+// newBurstReplies is used to collect the replies to a number of requests
+func newBurstReplies(count int) *burstReplies {
+	return &burstReplies{
+		count:        count,
+		allRecv:      make(chan struct{}),
+		rtts:         make(map[int]time.Duration),
+		dups:         make(map[int]int),
+		reordered:    make(map[int]bool),
+		unreachables: make(map[int]bool),
+		maxSeq:       -1,
+	}
+}
+
+// reply will record the reply to a request
+func (r *burstReplies) reply(seq int, rtt time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if seq >= r.count {
+		return
+	}
+	if _, ok := r.rtts[seq]; ok {
+		r.dups[seq]++
+		return
+	}
+	if seq < r.maxSeq {
+		r.reordered[seq] = true
+	} else {
+		r.maxSeq = seq
+	}
+	r.rtts[seq] = rtt
+	delete(r.unreachables, seq)
+	r.checkDone()
+}
+
+// unreachable will record an unreachable error for a request
+func (r *burstReplies) unreachable(seq int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if seq >= r.count || r.unreachables[seq] {
+		return
+	}
+	if _, ok := r.rtts[seq]; ok {
+		return
+	}
+	r.unreachables[seq] = true
+	r.checkDone()
+}
+
+// samples returns the samples of the requests sent in the order they were sent
+func (r *burstReplies) samples(sent int) []Sample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	samples := make([]Sample, sent)
+	for seq := range samples {
+		samples[seq].RTT, samples[seq].Recv = r.rtts[seq]
+		samples[seq].Dups = r.dups[seq]
+		samples[seq].Reordered = r.reordered[seq]
+		if !samples[seq].Recv && r.unreachables[seq] {
+			samples[seq].Err = errUnreachable
+		}
+	}
+	return samples
+}
+
+// checkDone will signal once every request has a reply or error
+func (r *burstReplies) checkDone() {
+	if !r.done && len(r.rtts)+len(r.unreachables) == r.count {
+		close(r.allRecv)
+		r.done = true
+	}
+}
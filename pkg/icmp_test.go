@@ -0,0 +1,93 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBurstReplies(t *testing.T) {
+	// replyEvent is a reply or an unreachable error, unreachable errors have
+	// no RTT
+	type replyEvent struct {
+		seq         int
+		rtt         float64
+		unreachable bool
+	}
+	tests := []struct {
+		name     string
+		events   []replyEvent
+		sent     int
+		want     []Sample
+		wantDone bool
+	}{
+		{
+			name:     "in order",
+			events:   []replyEvent{{seq: 0, rtt: 1}, {seq: 1, rtt: 2}, {seq: 2, rtt: 3}},
+			sent:     3,
+			want:     []Sample{{RTT: ms(1), Recv: true}, {RTT: ms(2), Recv: true}, {RTT: ms(3), Recv: true}},
+			wantDone: true,
+		},
+		{
+			name:     "out of order",
+			events:   []replyEvent{{seq: 0, rtt: 1}, {seq: 2, rtt: 2}, {seq: 1, rtt: 4}},
+			sent:     3,
+			want:     []Sample{{RTT: ms(1), Recv: true}, {RTT: ms(4), Recv: true, Reordered: true}, {RTT: ms(2), Recv: true}},
+			wantDone: true,
+		},
+		{
+			name:     "duplicated",
+			events:   []replyEvent{{seq: 0, rtt: 1}, {seq: 0, rtt: 5}, {seq: 1, rtt: 2}, {seq: 0, rtt: 6}},
+			sent:     3,
+			want:     []Sample{{RTT: ms(1), Recv: true, Dups: 2}, {RTT: ms(2), Recv: true}, {}},
+			wantDone: false,
+		},
+		{
+			name:     "unreachable",
+			events:   []replyEvent{{seq: 0, rtt: 1}, {seq: 1, unreachable: true}, {seq: 2, unreachable: true}, {seq: 2, rtt: 3}},
+			sent:     3,
+			want:     []Sample{{RTT: ms(1), Recv: true}, {Err: errUnreachable}, {RTT: ms(3), Recv: true}},
+			wantDone: true,
+		},
+		{
+			name:     "unknown sequence",
+			events:   []replyEvent{{seq: 3, rtt: 1}, {seq: 4, unreachable: true}},
+			sent:     3,
+			want:     []Sample{{}, {}, {}},
+			wantDone: false,
+		},
+		{
+			name:     "fewer sent",
+			events:   []replyEvent{{seq: 0, rtt: 1}},
+			sent:     2,
+			want:     []Sample{{RTT: ms(1), Recv: true}, {}},
+			wantDone: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newBurstReplies(3)
+			for _, e := range tt.events {
+				if e.unreachable {
+					r.unreachable(e.seq)
+				} else {
+					r.reply(e.seq, ms(e.rtt))
+				}
+			}
+			if got := r.samples(tt.sent); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("samples() = %+v, want %+v", got, tt.want)
+			}
+			done := false
+			select {
+			case <-r.allRecv:
+				done = true
+			default:
+			}
+			if done != tt.wantDone {
+				t.Errorf("allRecv closed = %v, want %v", done, tt.wantDone)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"net"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// icmpReadBuffer is the receive buffer requested for shared sockets so that
+// bursts of replies from many targets are not dropped
+const icmpReadBuffer int = 4 << 20
+
+// stampedConn is an ICMP socket which reads the kernel receive timestamp of
+// each message, so the RTT does not include the time waiting to be scheduled
+type stampedConn struct {
+	net.PacketConn
+	rc       syscall.RawConn
+	ipHeader bool // Raw IPv4 sockets include the IP header
+	oob      []byte
+}
+
+// listenICMP will open an ICMP socket with the socket options applied and
+// kernel receive timestamps enabled, the socket is opened from inside the
+// network namespace of the key
+func listenICMP(key socketKey) (icmpConn, error) {
+	var src net.IP
+	var conn net.PacketConn
+	err := inNetns(key.netns, func() error {
+		var err error
+		if key.source != "" {
+			src, err = sourceIP(key.source, key.ipv4)
+			if err != nil {
+				return err
+			}
+		}
+
+		lc := net.ListenConfig{Control: vrfControl(key.vrf)}
+		switch {
+		case key.privileged && key.ipv4:
+			conn, err = lc.ListenPacket(context.Background(), "ip4:icmp", ipString(src))
+		case key.privileged:
+			conn, err = lc.ListenPacket(context.Background(), "ip6:ipv6-icmp", ipString(src))
+		default:
+			conn, err = listenDatagramICMP(key.ipv4, src, key.vrf)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if b, ok := conn.(interface{ SetReadBuffer(int) error }); ok {
+		b.SetReadBuffer(icmpReadBuffer)
+	}
+
+	rc, err := conn.(syscall.Conn).SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		sockErr = setSocketOptions(fd, !key.ipv4, key.ttl, key.tos)
+		if sockErr == nil {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
+		}
+		if sockErr == nil && key.df {
+			sockErr = setDontFragment(fd, !key.ipv4)
+		}
+		if sockErr == nil && src != nil && net.ParseIP(key.source) == nil {
+			sockErr = bindDevice(fd, key.source)
+		}
+	})
+	if err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		conn.Close()
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+
+	return &stampedConn{
+		PacketConn: conn,
+		rc:         rc,
+		ipHeader:   key.privileged && key.ipv4,
+		oob:        make([]byte, syscall.CmsgSpace(int(unsafe.Sizeof(syscall.Timespec{})))),
+	}, nil
+}
+
+// listenDatagramICMP will open an unprivileged ICMP socket bound to the VRF
+// and source address when they are set
+func listenDatagramICMP(isIPv4 bool, src net.IP, vrf string) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	sa4, sa6 := &syscall.SockaddrInet4{}, &syscall.SockaddrInet6{}
+	var sa syscall.Sockaddr = sa4
+	if !isIPv4 {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+		sa = sa6
+	}
+	if src != nil {
+		copy(sa4.Addr[:], src.To4())
+		copy(sa6.Addr[:], src.To16())
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if vrf != "" {
+		if err := bindVRF(uintptr(fd), vrf); err != nil {
+			syscall.Close(fd)
+			return nil, os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	f := os.NewFile(uintptr(fd), "datagram-oriented icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+// setDontFragment will set the don't fragment flag on the packets sent by a
+// socket, the cached path MTU is ignored so packets larger than the path MTU
+// are sent and dropped by the network instead of failing to send
+func setDontFragment(fd uintptr, ipv6 bool) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+}
+
+// readICMP will read a single ICMP message and its kernel receive timestamp
+func (c *stampedConn) readICMP(b []byte) (int, time.Time, error) {
+	var n, oobn int
+	var readErr error
+	err := c.rc.Read(func(fd uintptr) bool {
+		n, oobn, _, _, readErr = syscall.Recvmsg(int(fd), b, c.oob, 0)
+		return readErr != syscall.EAGAIN
+	})
+	if err == nil {
+		err = readErr
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	received := time.Now()
+	if msgs, err := syscall.ParseSocketControlMessage(c.oob[:oobn]); err == nil {
+		for _, m := range msgs {
+			if m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SCM_TIMESTAMPNS &&
+				len(m.Data) >= int(unsafe.Sizeof(syscall.Timespec{})) {
+				ts := (*syscall.Timespec)(unsafe.Pointer(&m.Data[0]))
+				received = time.Unix(ts.Unix())
+			}
+		}
+	}
+
+	if c.ipHeader && n > 0 {
+		hdrLen := int(b[0]&0x0f) << 2
+		if hdrLen > n {
+			return 0, received, nil
+		}
+		n = copy(b, b[hdrLen:n])
+	}
+	return n, received, nil
+}
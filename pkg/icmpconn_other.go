@@ -0,0 +1,87 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+//go:build !linux
+// +build !linux
+
+package ping
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+// plainConn is an ICMP socket which uses the time a message is read as the
+// receive time
+type plainConn struct {
+	*icmp.PacketConn
+}
+
+// listenICMP will open an ICMP socket with the socket options applied
+func listenICMP(key socketKey) (icmpConn, error) {
+	network := "udp4"
+	if key.privileged {
+		network = "ip4:icmp"
+	}
+	if !key.ipv4 {
+		network = "udp6"
+		if key.privileged {
+			network = "ip6:ipv6-icmp"
+		}
+	}
+
+	if key.vrf != "" {
+		return nil, errors.New("VRFs are only supported on Linux")
+	}
+	if key.df {
+		return nil, errors.New("path MTU probes are only supported on Linux")
+	}
+
+	addr := ""
+	if key.source != "" {
+		src, err := sourceIP(key.source, key.ipv4)
+		if err != nil {
+			return nil, err
+		}
+		addr = src.String()
+	}
+
+	var conn *icmp.PacketConn
+	err := inNetns(key.netns, func() error {
+		var err error
+		conn, err = icmp.ListenPacket(network, addr)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if key.ipv4 {
+		if key.ttl > 0 {
+			err = conn.IPv4PacketConn().SetTTL(key.ttl)
+		}
+		if err == nil && key.tos > 0 {
+			err = conn.IPv4PacketConn().SetTOS(key.tos)
+		}
+	} else {
+		if key.ttl > 0 {
+			err = conn.IPv6PacketConn().SetHopLimit(key.ttl)
+		}
+		if err == nil && key.tos > 0 {
+			err = conn.IPv6PacketConn().SetTrafficClass(key.tos)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return plainConn{conn}, nil
+}
+
+// readICMP will read a single ICMP message
+func (c plainConn) readICMP(b []byte) (int, time.Time, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, time.Now(), err
+}
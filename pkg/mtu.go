@@ -0,0 +1,113 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"syscall"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	mtuMaxDefault int = 1500 // Largest MTU tested when the target has no maxmtu
+	mtuMinIPv4    int = 68   // Smallest MTU of any IPv4 link
+	mtuMinIPv6    int = 1280 // Smallest MTU of any IPv6 link
+	mtuAttempts   int = 2    // Requests sent at each size before it is too large
+)
+
+func init() {
+	Register("mtu", mtuProber{})
+}
+
+// mtuProber finds the path MTU to targets such as `mtu://10.0.0.1` by sending
+// ICMP echo requests with the don't fragment flag set. The size of the
+// requests is binary searched for the largest which receives a reply.
+type mtuProber struct{}
+
+// Probe will discover the path MTU to a target, the RTT is measured from the
+// requests of the discovered size
+func (mtuProber) Probe(ctx context.Context, t config.Target, opts Options) (Result, error) {
+	pinger, err := newTargetPinger(t, opts.Privileged)
+	if err != nil {
+		return Result{}, err
+	}
+	pinger.count = mtuAttempts
+	pinger.df = true
+
+	overhead, low := ipv4.HeaderLen+8, mtuMinIPv4
+	if !pinger.ipv4 {
+		overhead, low = ipv6.HeaderLen+8, mtuMinIPv6
+	}
+	high := mtuMaxDefault
+	if t.MaxMTU > 0 {
+		high = t.MaxMTU
+	}
+	if high < low {
+		high = low
+	}
+
+	// fits will send requests which fill an MTU and return the samples, the
+	// MTU fits when any request receives a reply. Each size is sent by a new
+	// pinger so late replies to a larger size are not counted for it.
+	fits := func(mtu int) ([]Sample, bool, error) {
+		step := pinger.clone()
+		step.size = mtu - overhead
+		samples, err := step.run(ctx)
+		if errors.Is(err, syscall.EMSGSIZE) {
+			// Larger than the MTU of the local interface
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		for _, s := range samples {
+			if s.Recv {
+				return samples, true, nil
+			}
+		}
+		return samples, false, nil
+	}
+
+	samples, ok, err := fits(low)
+	if err != nil {
+		return Result{}, err
+	}
+	result := NewResult(t, samples)
+	result.IP = pinger.dst.IP.String()
+	if !ok {
+		// The target cannot be reached with the smallest MTU
+		return result, nil
+	}
+
+	// The largest MTU which fits is between good and bad
+	good, bad := low, high+1
+	for mtu := high; bad-good > 1 && ctx.Err() == nil; mtu = (good + bad) / 2 {
+		s, ok, err := fits(mtu)
+		if err != nil {
+			return Result{}, err
+		}
+		if ok {
+			good, samples = mtu, s
+		} else {
+			bad = mtu
+		}
+	}
+	log.Debug().Msgf("Path MTU to `%s` is %d", t.Name, good)
+
+	result = NewResult(t, samples)
+	result.IP = pinger.dst.IP.String()
+	result.Extra = map[string]interface{}{"MTU": good}
+	return result, nil
+}
+
+// Metrics returns the path MTU metric
+func (mtuProber) Metrics(t config.Target) []string {
+	return []string{"MTU"}
+}
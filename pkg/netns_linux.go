@@ -0,0 +1,63 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// netnsDir is where `ip netns` keeps the named network namespaces
+const netnsDir string = "/var/run/netns"
+
+// inNetns will run a function from inside a network namespace, any sockets
+// opened by the function stay in the namespace after it returns. The name can
+// be a namespace created with `ip netns add` or the path to a namespace file
+// such as `/proc/1234/ns/net`. The function is run directly when the name is
+// empty.
+func inNetns(name string, fn func() error) error {
+	if name == "" {
+		return fn()
+	}
+
+	path := name
+	if !strings.Contains(name, "/") {
+		path = netnsDir + "/" + name
+	}
+	ns, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("network namespace `%s` not found", name)
+	}
+	defer ns.Close()
+
+	// The namespace is changed for the current thread only, so the goroutine
+	// must not be moved to another thread until it is restored
+	runtime.LockOSThread()
+	orig, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer orig.Close()
+
+	if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("cannot enter network namespace `%s`: %s", name, err)
+	}
+	fnErr := fn()
+
+	if err := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); err != nil {
+		// The thread is left locked so it is destroyed when the goroutine
+		// exits instead of being reused in the wrong namespace
+		log.Error().Msgf("Cannot leave network namespace `%s`: %s", name, err)
+		return fnErr
+	}
+	runtime.UnlockOSThread()
+	return fnErr
+}
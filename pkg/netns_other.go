@@ -0,0 +1,18 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+//go:build !linux
+// +build !linux
+
+package ping
+
+import "errors"
+
+// inNetns will run a function directly, network namespaces are only supported
+// on Linux
+func inNetns(name string, fn func() error) error {
+	if name != "" {
+		return errors.New("network namespaces are only supported on Linux")
+	}
+	return fn()
+}
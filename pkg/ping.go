@@ -4,87 +4,374 @@
 package ping
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"math"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/adamkirchberger/pingsheet/pkg/config"
 
 	"github.com/rs/zerolog/log"
-	"github.com/sparrc/go-ping"
 )
 
 // Result holds a single target ping result
 type Result struct {
-	Target config.Target
-	RTT    float64
-	JTT    float64
-	Sent   int
-	Drops  int
+	Target  config.Target
+	Status  string
+	IP      string // Address which was tested
+	RTT     float64
+	JTT     float64
+	Sent    int
+	Drops   int
+	Min     float64
+	Max     float64
+	StdDev  float64
+	P50     float64
+	P95     float64
+	P99     float64
+	MaxLoss int
+	Dups    int
+	Reorder int
+	IPDV50  float64
+	IPDV95  float64
+	IPDV99  float64
+	RFactor float64
+	MOS     float64
+	Hops    int // Zero when the trace did not reach the target
+	Path    []string
+	Extra   map[string]interface{} // Values of additional probe metrics
+
+	combined bool // Result combines the results of several addresses
+}
+
+// Metrics returns the names of the metrics which are reported for a target,
+// these are used as the column suffix in the host sheet. The selected metrics,
+// STATUS and IP are reported for all targets; the target Prober adds its own.
+func Metrics(t config.Target, selected []string) []string {
+	metrics := make([]string, len(selected), len(selected)+2)
+	copy(metrics, selected)
+	metrics = append(metrics, "STATUS", "IP")
+	if p, ok := lookupProber(t.Scheme); ok {
+		metrics = append(metrics, p.Metrics(t)...)
+	}
+	if t.Trace != "" {
+		metrics = append(metrics, "HOPS")
+	}
+	return metrics
+}
+
+// Values returns the result values keyed by metric name, targets which could
+// not be tested only report the status so that no data is left empty
+func (r Result) Values() map[string]interface{} {
+	values := map[string]interface{}{"STATUS": r.Status, "IP": r.IP}
+	if r.Sent == 0 {
+		return values
+	}
+	for metric, val := range r.Extra {
+		values[metric] = val
+	}
+	for metric, val := range map[string]interface{}{
+		"RTT":     r.RTT,
+		"JTT":     r.JTT,
+		"SENT":    r.Sent,
+		"DROPS":   r.Drops,
+		"MIN":     r.Min,
+		"MAX":     r.Max,
+		"STDDEV":  r.StdDev,
+		"P50":     r.P50,
+		"P95":     r.P95,
+		"P99":     r.P99,
+		"MAXLOSS": r.MaxLoss,
+		"DUPS":    r.Dups,
+		"REORDER": r.Reorder,
+		"IPDV50":  r.IPDV50,
+		"IPDV95":  r.IPDV95,
+		"IPDV99":  r.IPDV99,
+		"RFACTOR": r.RFactor,
+		"MOS":     r.MOS,
+	} {
+		values[metric] = val
+	}
+	if r.Path != nil && r.Hops > 0 {
+		values["HOPS"] = r.Hops
+	}
+	if r.combined {
+		// The spread of RTT's cannot be combined from the results of each
+		// address so these are left empty
+		for _, metric := range []string{"STDDEV", "P50", "P95", "P99", "IPDV50", "IPDV95", "IPDV99"} {
+			delete(values, metric)
+		}
+	}
+	return values
 }
 
-// Results holds multiple result objects
-//type Results map[string]Result
+const (
+	defaultInterval = time.Second     // Default wait between probes to a target
+	defaultTimeout  = 3 * time.Second // Default wait for a probe reply
+)
 
-var wg sync.WaitGroup
+// DefaultConcurrency is the maximum number of probes which are run at the
+// same time when the run options do not set a limit
+const DefaultConcurrency int = 100
 
-// Run will perform pings and return the results
-func Run(count int, targets []config.Target, privileged bool) []Result {
-	results := make([]Result, 0)
-	resultsChan := make(chan Result, 1)
+// Run will perform probes to all targets and return the results in the same
+// order as the targets, targets which are not valid or have an unsupported
+// scheme have the invalid status and targets which cannot be tested have a
+// failed status. When the context is done no new probes are started and
+// running probes return the samples collected so far. Run is safe to call
+// from multiple goroutines.
+func Run(ctx context.Context, targets []config.Target, opts Options) []Result {
+	limit := opts.Concurrency
+	if limit <= 0 {
+		limit = DefaultConcurrency
+	}
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+
+	// Each probe only writes to the index of its own target
+	results := make([][]Result, len(targets))
+	probed := make([]config.Target, len(targets))
+	paths := make([][]string, len(targets))
+	reached := make([]bool, len(targets))
+
+	// start will run a probe once a slot is free, probes are not run when the
+	// context is done before a slot is free
+	start := func(probe func()) {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		if ctx.Err() != nil {
+			<-slots
+			return
+		}
 
-	// Run each test to targets
-	for idx, target := range targets {
 		wg.Add(1)
-		log.Debug().Msgf("Run ping %d: %s", idx+1, target.Name)
-		go pingTarget(target, count, 1, 3, privileged, resultsChan)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			probe()
+		}()
 	}
 
-	// Watch channel and append
-	go func() {
-		for r := range resultsChan {
-			results = append(results, r)
-			wg.Done()
+	// Run each test to targets
+	for idx, target := range targets {
+		idx, t := idx, withDefaults(target, opts.Count)
+
+		if t.Err != nil {
+			results[idx] = []Result{failedResult(t, fmt.Errorf("%w: %s", errInvalid, t.Err))}
+			continue
+		}
+		prober, ok := lookupProber(t.Scheme)
+		if !ok {
+			log.Warn().Msgf("Unsupported scheme `%s` for target `%s`", t.Scheme, t.Name)
+			results[idx] = []Result{failedResult(t, fmt.Errorf("%w: unsupported scheme `%s`", errInvalid, t.Scheme))}
+			continue
+		}
+		probed[idx] = t
+
+		if t.Trace != "" {
+			log.Debug().Msgf("Run trace %d: %s", idx+1, t.Name)
+			start(func() {
+				path, ok, err := traceTarget(ctx, t, t.Trace, time.Second)
+				if err != nil {
+					log.Warn().Msgf("Trace had an issue with target `%s`: %s", t.Name, err)
+					return
+				}
+				paths[idx], reached[idx] = path, ok
+			})
 		}
-	}()
+
+		log.Debug().Msgf("Run %s probe %d: %s", t.Scheme, idx+1, t.Name)
+		start(func() {
+			if !t.Expand {
+				results[idx] = []Result{probeTarget(ctx, prober, t, opts)}
+				return
+			}
+
+			rs, err := addressResults(ctx, t)
+			if err != nil {
+				log.Warn().Msgf("Probe had an issue with target `%s`: %s", t.Name, err)
+				results[idx] = []Result{failedResult(t, err)}
+				return
+			}
+			results[idx] = rs
+
+			// Each address is probed in its own slot, these are started once
+			// the slot used to resolve the target is free
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range rs[1:] {
+					sub := &rs[i+1]
+					start(func() { *sub = probeTarget(ctx, prober, sub.Target, opts) })
+				}
+			}()
+		})
+	}
 
 	wg.Wait()
-	return results
+
+	if ctx.Err() != nil {
+		log.Warn().Msgf("Probes were stopped early: %s", ctx.Err())
+	} else if opts.Streams != nil {
+		// Sessions are only kept when all targets have been flushed
+		opts.Streams.sweep()
+	}
+
+	ordered := make([]Result, 0, len(targets))
+	for idx, rs := range results {
+		if rs == nil {
+			// Targets which were not started before the context was done
+			// have timed out
+			rs = []Result{failedResult(probed[idx], ctx.Err())}
+		}
+		if len(rs) > 1 {
+			for i := range rs[1:] {
+				if rs[i+1].Status == "" {
+					rs[i+1] = failedResult(rs[i+1].Target, ctx.Err())
+				}
+			}
+			rs[0] = combineResults(rs[0].Target, rs[1:])
+		}
+		// Add discovered paths to results
+		if paths[idx] != nil {
+			rs[0].Path = paths[idx]
+			if reached[idx] {
+				rs[0].Hops = len(paths[idx])
+			}
+		}
+		ordered = append(ordered, rs...)
+	}
+	return ordered
 }
 
-// pingTarget will run a single test to a supplied target
-func pingTarget(t config.Target, count, interval, timeout int, privileged bool, results chan<- Result) {
-	pinger, err := ping.NewPinger(t.Name)
+// probeTarget will run a single probe to a target, a failed result is
+// returned when the target cannot be tested
+func probeTarget(ctx context.Context, prober Prober, t config.Target, opts Options) Result {
+	var r Result
+	var err error
+	if opts.Streams != nil && t.Scheme == "icmp" {
+		r, err = opts.Streams.flush(t, opts.Privileged)
+	} else {
+		r, err = prober.Probe(ctx, t, opts)
+	}
 	if err != nil {
-		log.Warn().Msgf("Ping had an issue with target `%s`: %s", t.Name, err)
-		wg.Done()
-		return
+		log.Warn().Msgf("Probe had an issue with target `%s`: %s", t.Name, err)
+		r = failedResult(t, err)
 	}
-	pinger.Count = count
-	pinger.Interval = time.Duration(interval) * time.Second
-	pinger.Timeout = time.Duration(timeout) * time.Second
-	pinger.SetPrivileged(privileged)
+	return r
+}
 
-	pinger.Run()
-	stats := pinger.Statistics()
+// addressResults will resolve all addresses of a target and return the
+// results to fill in for it. The first result is for the target and is
+// followed by a result for each address, which is named after the target and
+// address such as `web_192.0.2.10`.
+func addressResults(ctx context.Context, t config.Target) ([]Result, error) {
+	ips, err := resolveTarget(ctx, t)
+	if err != nil {
+		return nil, err
+	}
 
-	// Handle bug with sent packets and drop being negative
-	drops := stats.PacketsSent - stats.PacketsRecv
-	if drops < 0 {
-		drops = 0
+	results := make([]Result, len(ips)+1)
+	results[0].Target = t
+	for idx, ip := range ips {
+		sub := t
+		sub.Name, sub.IP = t.Name+"_"+ip, ip
+		results[idx+1].Target = sub
 	}
+	return results, nil
+}
 
-	result := Result{
-		Target: t,
-		RTT:    float64(stats.AvgRtt) / float64(time.Millisecond),
-		JTT:    calculateJitter(stats.Rtts),
-		Sent:   stats.PacketsSent,
-		Drops:  drops,
+// combineResults will create the result for a target from the results of each
+// of its addresses. The RTT and jitter are averaged over the replies of all
+// addresses, the status is ok when any address had a reply.
+func combineResults(t config.Target, results []Result) Result {
+	combined := Result{Target: t, Status: results[0].Status, combined: true}
+	ips := make([]string, 0, len(results))
+	var rtt, jtt float64
+	replied := false
+	for _, r := range results {
+		ips = append(ips, r.Target.IP)
+		if r.Sent == 0 {
+			continue
+		}
+		recv := float64(r.Sent - r.Drops)
+		if recv > 0 {
+			if !replied || r.Min < combined.Min {
+				combined.Min = r.Min
+			}
+			replied = true
+			combined.Status = StatusOK
+			combined.Max = math.Max(combined.Max, r.Max)
+		}
+		rtt += r.RTT * recv
+		jtt += r.JTT * recv
+		combined.Sent += r.Sent
+		combined.Drops += r.Drops
+		combined.Dups += r.Dups
+		combined.Reorder += r.Reorder
+		if r.MaxLoss > combined.MaxLoss {
+			combined.MaxLoss = r.MaxLoss
+		}
 	}
+	combined.IP = strings.Join(ips, ",")
 
-	// Save results
-	results <- result
+	if recv := combined.Sent - combined.Drops; recv > 0 {
+		combined.RTT = rtt / float64(recv)
+		combined.JTT = jtt / float64(recv)
+		loss := float64(combined.Drops) / float64(combined.Sent) * 100
+		combined.RFactor = calculateRFactor(combined.RTT, combined.JTT, loss)
+	}
+	combined.MOS = calculateMOS(combined.RFactor)
+	return combined
+}
+
+// resolveTarget will return all addresses of a target which match the target
+// address family
+func resolveTarget(ctx context.Context, t config.Target) ([]string, error) {
+	host, err := targetHost(t)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		isIPv4 := addr.IP.To4() != nil
+		if t.Family == config.FamilyV4 && !isIPv4 || t.Family == config.FamilyV6 && isIPv4 {
+			continue
+		}
+		ips = append(ips, addr.String())
+	}
+	if len(ips) == 0 {
+		return nil, &net.AddrError{Err: noSuitableAddress, Addr: host}
+	}
+	return ips, nil
+}
+
+// withDefaults will return a target with the default options applied where
+// the target has not set its own
+func withDefaults(t config.Target, count int) config.Target {
+	if t.Count == 0 {
+		t.Count = count
+	}
+	if t.Interval == 0 {
+		t.Interval = defaultInterval
+	}
+	if t.Timeout == 0 {
+		t.Timeout = defaultTimeout
+	}
+	return t
 }
 
 // CheckPingPermissions tests if root is required and present
@@ -110,24 +397,37 @@ func CheckPingPermissions() (bool, error) {
 
 // tryPing will send a single ping and return true if successful
 func tryPing(target string, privileged bool) bool {
-	pinger, err := ping.NewPinger(target)
-	pinger.Count = 1
-	pinger.Timeout = time.Duration(1) * time.Second
-	pinger.SetPrivileged(privileged)
+	pinger, err := newICMPPinger(target, "ip", privileged)
 	if err != nil {
 		log.Warn().Msgf("Ping error: %s", err)
 		return false
 	}
 
-	pinger.Run()
-	if pinger.Statistics().PacketsRecv == 1 {
-		return true
+	samples, err := pinger.run(context.Background())
+	if err != nil {
+		log.Debug().Msgf("Ping error: %s", err)
+		return false
+	}
+	return samples[0].Recv
+}
+
+// averageRTT in milliseconds from supplied slice of RTT's
+func averageRTT(rtts []time.Duration) float64 {
+	if len(rtts) == 0 {
+		return 0
 	}
-	return false
+	var total time.Duration
+	for _, rtt := range rtts {
+		total += rtt
+	}
+	return float64(total) / float64(len(rtts)) / float64(time.Millisecond)
 }
 
 // calculateJitter in milliseconds from supplied slice of RTT's
 func calculateJitter(rtts []time.Duration) float64 {
+	if len(rtts) < 2 {
+		return 0
+	}
 	var diff float64 = 0
 	for i := 1; i < len(rtts); i++ {
 		diff += math.Abs(float64(rtts[i-1]) - float64(rtts[i]))
@@ -0,0 +1,129 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+)
+
+func TestRunInvalid(t *testing.T) {
+	targets := []config.Target{
+		{Name: "10.0.0.1", Scheme: "icmp", Address: "10.0.0.1", Err: errors.New("`ttl` must be between 1 and 255")},
+		{Name: "tpc://db01:5432", Scheme: "tpc", Address: "db01:5432"},
+	}
+	results := Run(context.Background(), targets, Options{Count: 1})
+	if len(results) != len(targets) {
+		t.Fatalf("Run() returned %d results, want %d", len(results), len(targets))
+	}
+	for i, r := range results {
+		if r.Target.Name != targets[i].Name {
+			t.Errorf("Run() result %d is for %s, want %s", i, r.Target.Name, targets[i].Name)
+		}
+		if r.Status != StatusInvalid {
+			t.Errorf("Run() %s status = %s, want %s", r.Target.Name, r.Status, StatusInvalid)
+		}
+		if values := r.Values(); len(values) != 2 {
+			t.Errorf("Run() %s values = %v, want only STATUS and IP", r.Target.Name, values)
+		}
+	}
+}
+
+func TestCombineResults(t *testing.T) {
+	target := config.Target{Name: "example.com", Scheme: "icmp", Address: "example.com", Expand: true}
+	// addressResult returns the result of one address of the target
+	addressResult := func(ip, status string, sent, drops int, rtt, min, max float64) Result {
+		t := target
+		t.IP = ip
+		return Result{Target: t, Status: status, Sent: sent, Drops: drops, RTT: rtt, JTT: rtt / 10, Min: min, Max: max, MaxLoss: drops}
+	}
+	tests := []struct {
+		name       string
+		results    []Result
+		wantStatus string
+		wantIP     string
+		wantSent   int
+		wantDrops  int
+		wantLoss   int
+		wantRTT    float64
+		wantMin    float64
+		wantMax    float64
+	}{
+		{
+			name: "all replied",
+			results: []Result{
+				addressResult("10.0.0.1", StatusOK, 4, 0, 10, 8, 12),
+				addressResult("10.0.0.2", StatusOK, 4, 2, 40, 30, 50),
+			},
+			wantStatus: StatusOK,
+			wantIP:     "10.0.0.1,10.0.0.2",
+			wantSent:   8,
+			wantDrops:  2,
+			wantLoss:   2,
+			wantRTT:    20,
+			wantMin:    8,
+			wantMax:    50,
+		},
+		{
+			name: "one address timed out",
+			results: []Result{
+				addressResult("10.0.0.1", StatusTimeout, 2, 2, 0, 0, 0),
+				addressResult("10.0.0.2", StatusOK, 2, 0, 10, 9, 11),
+			},
+			wantStatus: StatusOK,
+			wantIP:     "10.0.0.1,10.0.0.2",
+			wantSent:   4,
+			wantDrops:  2,
+			wantLoss:   2,
+			wantRTT:    10,
+			wantMin:    9,
+			wantMax:    11,
+		},
+		{
+			name: "no replies",
+			results: []Result{
+				addressResult("10.0.0.1", StatusUnreachable, 0, 0, 0, 0, 0),
+				addressResult("10.0.0.2", StatusTimeout, 3, 3, 0, 0, 0),
+			},
+			wantStatus: StatusUnreachable,
+			wantIP:     "10.0.0.1,10.0.0.2",
+			wantSent:   3,
+			wantDrops:  3,
+			wantLoss:   3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := combineResults(target, tt.results)
+			if got.Target.Name != target.Name || got.Status != tt.wantStatus || got.IP != tt.wantIP {
+				t.Errorf("combineResults() = %s %s %s, want %s %s %s",
+					got.Target.Name, got.Status, got.IP, target.Name, tt.wantStatus, tt.wantIP)
+			}
+			if got.Sent != tt.wantSent || got.Drops != tt.wantDrops || got.MaxLoss != tt.wantLoss {
+				t.Errorf("combineResults() sent, drops, max loss = %d, %d, %d, want %d, %d, %d",
+					got.Sent, got.Drops, got.MaxLoss, tt.wantSent, tt.wantDrops, tt.wantLoss)
+			}
+			if !approxEqual(got.RTT, tt.wantRTT) || !approxEqual(got.JTT, tt.wantRTT/10) {
+				t.Errorf("combineResults() RTT, JTT = %v, %v, want %v, %v", got.RTT, got.JTT, tt.wantRTT, tt.wantRTT/10)
+			}
+			if !approxEqual(got.Min, tt.wantMin) || !approxEqual(got.Max, tt.wantMax) {
+				t.Errorf("combineResults() min, max = %v, %v, want %v, %v", got.Min, got.Max, tt.wantMin, tt.wantMax)
+			}
+			wantMOS := 1.0
+			if tt.wantSent > tt.wantDrops {
+				loss := float64(tt.wantDrops) / float64(tt.wantSent) * 100
+				wantMOS = calculateMOS(calculateRFactor(tt.wantRTT, tt.wantRTT/10, loss))
+			}
+			if !approxEqual(got.MOS, wantMOS) {
+				t.Errorf("combineResults() MOS = %v, want %v", got.MOS, wantMOS)
+			}
+			if _, ok := got.Values()["P95"]; ok {
+				t.Errorf("combineResults() values have P95, want it left empty")
+			}
+		})
+	}
+}
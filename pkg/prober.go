@@ -0,0 +1,110 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+)
+
+// Prober is implemented by each probe type. Probers are registered by the URL
+// scheme of the targets they test such as `tcp` for `tcp://db01:5432`.
+type Prober interface {
+	// Probe will test a target and return the result. The target count,
+	// interval and timeout are always set when a probe is run. When the
+	// context is done the probe should stop and return the samples collected
+	// so far. An error is returned when the target cannot be tested at all
+	// and is used to set the status of the target.
+	Probe(ctx context.Context, t config.Target, opts Options) (Result, error)
+
+	// Metrics returns the names of the metrics the probe reports for a target
+	// in addition to the metrics selected for the host
+	Metrics(t config.Target) []string
+}
+
+// Options holds the settings which apply to all probes in a run
+type Options struct {
+	Count       int  // Probes sent to targets which do not set a count
+	Concurrency int  // Maximum probes run at the same time
+	Privileged  bool // Use raw sockets for ICMP
+
+	// Streams is used for ICMP targets in continuous mode, the results are
+	// collected from the continuous sessions instead of running a probe
+	Streams *Streams
+}
+
+var (
+	probersMu sync.RWMutex
+	probers   = make(map[string]Prober)
+)
+
+// Register will make a Prober available for targets using a scheme, an
+// existing Prober for the scheme is replaced
+func Register(scheme string, p Prober) {
+	probersMu.Lock()
+	defer probersMu.Unlock()
+	probers[strings.ToLower(scheme)] = p
+}
+
+// Schemes returns the sorted names of all registered schemes
+func Schemes() []string {
+	probersMu.RLock()
+	defer probersMu.RUnlock()
+	schemes := make([]string, 0, len(probers))
+	for scheme := range probers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// lookupProber will return the Prober registered for a scheme
+func lookupProber(scheme string) (Prober, bool) {
+	probersMu.RLock()
+	defer probersMu.RUnlock()
+	p, ok := probers[scheme]
+	return p, ok
+}
+
+// Series will call the probe function once for each probe to send to the
+// target, waiting for the target interval between each call. The function
+// returns the RTT of a successful probe or an error when it failed. No more
+// probes are sent once the context is done.
+func Series(ctx context.Context, t config.Target, probe func(seq int) (time.Duration, error)) []Sample {
+	samples := make([]Sample, 0, t.Count)
+	for seq := 0; seq < t.Count; seq++ {
+		if seq > 0 && !sleep(ctx, t.Interval) {
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		rtt, err := probe(seq)
+		if err != nil {
+			samples = append(samples, Sample{Err: err})
+			continue
+		}
+		samples = append(samples, Sample{RTT: rtt, Recv: true})
+	}
+	return samples
+}
+
+// sleep will wait for the duration and return false if the context is done
+// before the duration has passed
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+)
+
+// fakeProber reports a fixed RTT and an additional metric for each target
+type fakeProber struct{}
+
+// Probe will return a result with a sample for each probe
+func (fakeProber) Probe(ctx context.Context, t config.Target, opts Options) (Result, error) {
+	samples := Series(ctx, t, func(seq int) (time.Duration, error) {
+		return ms(5), nil
+	})
+	result := NewResult(t, samples)
+	result.IP = t.Address
+	result.Extra = map[string]interface{}{"ANSWER": 42}
+	return result, nil
+}
+
+// Metrics returns the additional metric
+func (fakeProber) Metrics(t config.Target) []string {
+	return []string{"ANSWER"}
+}
+
+func TestRegisteredProber(t *testing.T) {
+	Register("FAKE", fakeProber{})
+	defer func() {
+		probersMu.Lock()
+		delete(probers, "fake")
+		probersMu.Unlock()
+	}()
+
+	target := config.Target{Name: "answer", Scheme: "fake", Address: "deep-thought", Interval: time.Millisecond}
+	wantMetrics := []string{"RTT", "STATUS", "IP", "ANSWER"}
+	if got := Metrics(target, []string{"RTT"}); !reflect.DeepEqual(got, wantMetrics) {
+		t.Errorf("Metrics() = %v, want %v", got, wantMetrics)
+	}
+
+	results := Run(context.Background(), []config.Target{target}, Options{Count: 2})
+	if len(results) != 1 {
+		t.Fatalf("Run() returned %d results, want 1", len(results))
+	}
+	values := results[0].Values()
+	for metric, want := range map[string]interface{}{
+		"STATUS": StatusOK,
+		"IP":     "deep-thought",
+		"SENT":   2,
+		"RTT":    5.0,
+		"ANSWER": 42,
+	} {
+		if values[metric] != want {
+			t.Errorf("Values()[%s] = %v, want %v", metric, values[metric], want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import "math"
+
+// E-model parameters for G.711 with packet loss concealment, from ITU-T G.113
+const (
+	voiceBaseR   float64 = 93.2 // R-factor with no impairments
+	voiceIe      float64 = 0    // Equipment impairment of the codec
+	voiceBpl     float64 = 25.1 // Packet loss robustness of the codec
+	voiceBurstR  float64 = 1    // Burst ratio for random loss
+	voiceDelayMs float64 = 177.3
+)
+
+// calculateRFactor returns an ITU-T G.107 R-factor estimate for a voice call
+// over the path. The one way delay is taken as half the average RTT plus a
+// jitter buffer of twice the jitter, the loss is a percentage.
+func calculateRFactor(rtt, jitter, loss float64) float64 {
+	delay := rtt/2 + 2*jitter
+
+	// Delay impairment
+	id := 0.024 * delay
+	if delay > voiceDelayMs {
+		id += 0.11 * (delay - voiceDelayMs)
+	}
+
+	// Effective equipment impairment including packet loss
+	ie := voiceIe + (95-voiceIe)*loss/(loss/voiceBurstR+voiceBpl)
+
+	r := voiceBaseR - id - ie
+	return math.Max(0, math.Min(100, r))
+}
+
+// calculateMOS returns the estimated mean opinion score for an R-factor using
+// the conversion from ITU-T G.107 Annex B
+func calculateMOS(r float64) float64 {
+	switch {
+	case r <= 0:
+		return 1
+	case r >= 100:
+		return 4.5
+	}
+	return 1 + 0.035*r + r*(r-60)*(100-r)*7e-6
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import "testing"
+
+func TestCalculateRFactor(t *testing.T) {
+	tests := []struct {
+		name   string
+		rtt    float64
+		jitter float64
+		loss   float64
+		want   float64
+	}{
+		{"no impairments", 0, 0, 0, 93.2},
+		{"delay below threshold", 100, 0, 0, 93.2 - 0.024*50},
+		{"jitter buffer", 100, 10, 0, 93.2 - 0.024*70},
+		{"delay above threshold", 400, 0, 0, 93.2 - 0.024*200 - 0.11*(200-177.3)},
+		{"loss", 0, 0, 1, 93.2 - 95*1/(1+25.1)},
+		{"all lost", 0, 0, 100, 93.2 - 95*100/(100+25.1)},
+		{"clamped to zero", 2000, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calculateRFactor(tt.rtt, tt.jitter, tt.loss); !approxEqual(got, tt.want) {
+				t.Errorf("calculateRFactor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateMOS(t *testing.T) {
+	tests := []struct {
+		name string
+		r    float64
+		want float64
+	}{
+		{"negative", -10, 1},
+		{"zero", 0, 1},
+		{"fifty", 50, 2.575},
+		{"sixty", 60, 3.1},
+		{"no impairments", 93.2, 1 + 0.035*93.2 + 93.2*33.2*6.8*7e-6},
+		{"hundred", 100, 4.5},
+		{"above hundred", 120, 4.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calculateMOS(tt.r); !approxEqual(got, tt.want) {
+				t.Errorf("calculateMOS() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
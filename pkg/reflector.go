@@ -0,0 +1,62 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Reflector answers the UDP echo requests sent to `udp://` targets, so
+// pingsheet hosts can test the UDP paths between each other
+type Reflector struct {
+	conn net.PacketConn
+}
+
+// NewReflector is used to create a Reflector listening on an address such as
+// `:8862`
+func NewReflector(addr string) (*Reflector, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Reflector{conn: conn}, nil
+}
+
+// Addr returns the address the reflector is listening on
+func (r *Reflector) Addr() net.Addr {
+	return r.conn.LocalAddr()
+}
+
+// Serve will answer echo requests until the reflector is closed, packets
+// which are not echo requests are ignored
+func (r *Reflector) Serve() error {
+	buf := make([]byte, 65536)
+	for {
+		n, peer, err := r.conn.ReadFrom(buf)
+		received := time.Now()
+		if err != nil {
+			return err
+		}
+
+		b := buf[:n]
+		if n < udpEchoHeaderLen || binary.BigEndian.Uint32(b[0:4]) != udpEchoMagic || b[4] != udpEchoRequest {
+			continue
+		}
+		b[4] = udpEchoReply
+		binary.BigEndian.PutUint64(b[32:40], uint64(received.UnixNano()))
+		binary.BigEndian.PutUint64(b[40:48], uint64(time.Now().UnixNano()))
+		if _, err := r.conn.WriteTo(b, peer); err != nil {
+			log.Debug().Msgf("Reflector could not reply to %s: %s", peer, err)
+		}
+	}
+}
+
+// Close will stop the reflector
+func (r *Reflector) Close() error {
+	return r.conn.Close()
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+)
+
+func TestReflectorRoundTrip(t *testing.T) {
+	ref, err := NewReflector("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReflector() error = %v", err)
+	}
+	defer ref.Close()
+	go ref.Serve()
+
+	target := config.Target{
+		Name:     "reflector",
+		Scheme:   "udp",
+		Address:  ref.Addr().String(),
+		Count:    3,
+		Interval: 10 * time.Millisecond,
+		Timeout:  time.Second,
+		Size:     100,
+	}
+	got, err := udpProber{}.Probe(context.Background(), target, Options{})
+	if err != nil {
+		t.Fatalf("Probe() error = %v", err)
+	}
+	if got.Status != StatusOK || got.IP != "127.0.0.1" {
+		t.Errorf("Probe() status, IP = %s, %s, want %s, 127.0.0.1", got.Status, got.IP, StatusOK)
+	}
+	if got.Sent != 3 || got.Drops != 0 || got.Dups != 0 {
+		t.Errorf("Probe() sent, drops, dups = %d, %d, %d, want 3, 0, 0", got.Sent, got.Drops, got.Dups)
+	}
+}
+
+func TestReflectorClosed(t *testing.T) {
+	ref, err := NewReflector("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReflector() error = %v", err)
+	}
+	addr := ref.Addr().String()
+	ref.Close()
+
+	target := config.Target{
+		Name:     "reflector",
+		Scheme:   "udp",
+		Address:  addr,
+		Count:    2,
+		Interval: 10 * time.Millisecond,
+		Timeout:  100 * time.Millisecond,
+	}
+	got, err := udpProber{}.Probe(context.Background(), target, Options{})
+	if err != nil {
+		t.Fatalf("Probe() error = %v", err)
+	}
+	if got.Status != StatusUnreachable || got.Drops != 2 {
+		t.Errorf("Probe() status, drops = %s, %d, want %s, 2", got.Status, got.Drops, StatusUnreachable)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+)
+
+// RouteChange holds a change in the discovered path to a target
+type RouteChange struct {
+	Target config.Target
+	Old    []string
+	New    []string
+	Time   time.Time
+}
+
+// RouteTracker keeps the last known path to each target so that route changes
+// can be detected between path discoveries
+type RouteTracker struct {
+	mu    sync.Mutex
+	paths map[string][]string
+}
+
+// NewRouteTracker is used to create a new RouteTracker instance
+func NewRouteTracker() *RouteTracker {
+	return &RouteTracker{
+		paths: make(map[string][]string),
+	}
+}
+
+// Update will record the paths in the supplied results and return a change
+// for each target where the path differs from the last known path. The first
+// path discovered for a target is recorded without a change, and paths of
+// traces which did not reach the target are ignored.
+func (rt *RouteTracker) Update(results []Result) []RouteChange {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	changes := make([]RouteChange, 0)
+	now := time.Now()
+	for _, r := range results {
+		if len(r.Path) == 0 || r.Hops == 0 {
+			continue
+		}
+
+		old, ok := rt.paths[r.Target.Name]
+		if !ok {
+			rt.paths[r.Target.Name] = r.Path
+			continue
+		}
+
+		if pathChanged(old, r.Path) {
+			changes = append(changes, RouteChange{
+				Target: r.Target,
+				Old:    old,
+				New:    r.Path,
+				Time:   now,
+			})
+			rt.paths[r.Target.Name] = r.Path
+		} else {
+			rt.paths[r.Target.Name] = mergePaths(old, r.Path)
+		}
+	}
+	return changes
+}
+
+// pathChanged compares the hops of two paths which reached the target. Hops
+// which did not respond in either path are not treated as a change as many
+// routers rate limit replies, and extra hops before the target which did not
+// respond are the target itself not replying to a lower TTL.
+func pathChanged(old, new []string) bool {
+	if old[len(old)-1] != new[len(new)-1] {
+		return true
+	}
+	old, new = old[:len(old)-1], new[:len(new)-1]
+	for idx := 0; idx < len(old) || idx < len(new); idx++ {
+		switch {
+		case idx >= len(old):
+			if new[idx] != traceHop {
+				return true
+			}
+		case idx >= len(new):
+			if old[idx] != traceHop {
+				return true
+			}
+		case old[idx] != traceHop && new[idx] != traceHop && old[idx] != new[idx]:
+			return true
+		}
+	}
+	return false
+}
+
+// mergePaths fills hops which did not respond in the new path with the hops
+// from an old path which has not changed
+func mergePaths(old, new []string) []string {
+	merged := make([]string, len(new))
+	for idx := range new {
+		merged[idx] = new[idx]
+		if merged[idx] == traceHop && idx < len(old)-1 {
+			merged[idx] = old[idx]
+		}
+	}
+	return merged
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+)
+
+// traceResult returns a result with a path, the hop count is only set when
+// the trace reached the target
+func traceResult(reached bool, path ...string) Result {
+	r := Result{Target: config.Target{Name: "gw"}, Path: path}
+	if reached {
+		r.Hops = len(path)
+	}
+	return r
+}
+
+func TestRouteTrackerUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []Result
+		want    [][2][]string // Old and new path of each change
+	}{
+		{
+			name:    "first path",
+			results: []Result{traceResult(true, "a", "b", "dst")},
+		},
+		{
+			name:    "same path",
+			results: []Result{traceResult(true, "a", "b", "dst"), traceResult(true, "a", "b", "dst")},
+		},
+		{
+			name:    "hop did not respond",
+			results: []Result{traceResult(true, "a", "b", "dst"), traceResult(true, "a", "*", "dst")},
+		},
+		{
+			name:    "hop responds again",
+			results: []Result{traceResult(true, "a", "*", "dst"), traceResult(true, "a", "b", "dst")},
+		},
+		{
+			name:    "target answered at a later TTL",
+			results: []Result{traceResult(true, "a", "b", "dst"), traceResult(true, "a", "b", "*", "dst")},
+		},
+		{
+			name:    "target answered at an earlier TTL",
+			results: []Result{traceResult(true, "a", "b", "*", "dst"), traceResult(true, "a", "b", "dst")},
+		},
+		{
+			name:    "empty path",
+			results: []Result{traceResult(true, "a", "b", "dst"), traceResult(false), traceResult(true, "a", "b", "dst")},
+		},
+		{
+			name:    "target not reached",
+			results: []Result{traceResult(true, "a", "b", "dst"), traceResult(false, "a"), traceResult(true, "a", "b", "dst")},
+		},
+		{
+			name:    "no path",
+			results: []Result{traceResult(true, "a", "b", "dst"), {Target: config.Target{Name: "gw"}}},
+		},
+		{
+			name:    "hop changed",
+			results: []Result{traceResult(true, "a", "b", "dst"), traceResult(true, "a", "c", "dst")},
+			want:    [][2][]string{{{"a", "b", "dst"}, {"a", "c", "dst"}}},
+		},
+		{
+			name:    "path is shorter",
+			results: []Result{traceResult(true, "a", "b", "dst"), traceResult(true, "a", "dst")},
+			want:    [][2][]string{{{"a", "b", "dst"}, {"a", "dst"}}},
+		},
+		{
+			name:    "path is longer",
+			results: []Result{traceResult(true, "a", "dst"), traceResult(true, "a", "b", "dst")},
+			want:    [][2][]string{{{"a", "dst"}, {"a", "b", "dst"}}},
+		},
+		{
+			name: "change after hop did not respond",
+			results: []Result{
+				traceResult(true, "a", "b", "dst"),
+				traceResult(true, "a", "*", "dst"),
+				traceResult(true, "a", "c", "dst"),
+			},
+			want: [][2][]string{{{"a", "b", "dst"}, {"a", "c", "dst"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewRouteTracker()
+			got := make([][2][]string, 0)
+			for _, r := range tt.results {
+				for _, c := range rt.Update([]Result{r}) {
+					got = append(got, [2][]string{c.Old, c.New})
+				}
+			}
+			if len(got) != len(tt.want) || len(got) > 0 && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Update() changes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+)
+
+// targetNetwork will return the network to use for a target, such as `tcp6`
+// for a `tcp` target which only uses IPv6
+func targetNetwork(t config.Target, network string) string {
+	switch t.Family {
+	case config.FamilyV4:
+		return network + "4"
+	case config.FamilyV6:
+		return network + "6"
+	}
+	return network
+}
+
+// dialAddress will return the address to connect to for a target, expanded
+// targets connect to their resolved address instead of the host name
+func dialAddress(t config.Target, addr string) string {
+	if t.IP == "" {
+		return addr
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return t.IP
+	}
+	return net.JoinHostPort(t.IP, port)
+}
+
+// remoteIP returns the IP address of the remote end of a connection
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// targetDialer is a dialer which connects from the network namespace of a
+// target
+type targetDialer struct {
+	*net.Dialer
+	netns string
+}
+
+// DialContext will connect to an address from the target network namespace.
+// Only the thread which runs inNetns is in the namespace, so host names are
+// resolved first and each address is dialled as an IP literal with the
+// network pinned to its family. This stops the dialer from resolving or
+// racing connects on other threads.
+func (d targetDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.netns == "" {
+		return d.Dialer.DialContext(ctx, network, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := *d.Dialer
+	dialer.FallbackDelay = -1
+	base := strings.TrimRight(network, "46")
+	var conn net.Conn
+	err = &net.AddrError{Err: noSuitableAddress, Addr: host}
+	for _, addr := range addrs {
+		pinned := base + "6"
+		if addr.IP.To4() != nil {
+			pinned = base + "4"
+		}
+		if network != base && network != pinned {
+			continue
+		}
+		err = inNetns(d.netns, func() error {
+			var err error
+			conn, err = dialer.DialContext(ctx, pinned, net.JoinHostPort(addr.IP.String(), port))
+			return err
+		})
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	return conn, err
+}
+
+// newDialer will create a dialer which applies the target TTL and DSCP to the
+// socket and binds it to the target VRF and source before connecting
+func newDialer(t config.Target) targetDialer {
+	dialer := &net.Dialer{
+		Timeout: t.Timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			if t.TTL == 0 && t.DSCP == 0 && t.Source == "" && t.VRF == "" {
+				return nil
+			}
+
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				isIPv6 := strings.HasSuffix(network, "6")
+				sockErr = setSocketOptions(fd, isIPv6, t.TTL, t.DSCP<<2)
+				if sockErr == nil && t.VRF != "" {
+					sockErr = bindVRF(fd, t.VRF)
+				}
+				if sockErr == nil && t.Source != "" {
+					sockErr = bindSource(fd, t.Source, !isIPv6)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	return targetDialer{Dialer: dialer, netns: t.Netns}
+}
+
+// vrfControl returns a socket control function which binds sockets to a VRF
+// before they are bound to an address, it does nothing when the VRF is empty
+func vrfControl(vrf string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if vrf == "" {
+			return nil
+		}
+
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = bindVRF(fd, vrf)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+//go:build windows
+// +build windows
+
+package ping
+
+import (
+	"errors"
+	"syscall"
+)
+
+// bindAddr will bind a socket to a local address
+func bindAddr(fd uintptr, sa syscall.Sockaddr) error {
+	return syscall.Bind(syscall.Handle(fd), sa)
+}
+
+// setSocketOptions will set the TTL and TOS of a socket when they are set
+func setSocketOptions(fd uintptr, ipv6 bool, ttl, tos int) error {
+	level, ttlOpt, tosOpt := syscall.IPPROTO_IP, syscall.IP_TTL, syscall.IP_TOS
+	if ipv6 {
+		if tos > 0 {
+			return errors.New("setting traffic class is not supported")
+		}
+		level, ttlOpt = syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS
+	}
+
+	if ttl > 0 {
+		if err := syscall.SetsockoptInt(syscall.Handle(fd), level, ttlOpt, ttl); err != nil {
+			return err
+		}
+	}
+	if tos > 0 {
+		if err := syscall.SetsockoptInt(syscall.Handle(fd), level, tosOpt, tos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// sourceIP returns the address to bind to for a probe source, which can be an
+// IP address or the name of an interface. The first address of the interface
+// which matches the address family is used.
+func sourceIP(source string, isIPv4 bool) (net.IP, error) {
+	if ip := net.ParseIP(source); ip != nil {
+		if (ip.To4() != nil) != isIPv4 {
+			return nil, fmt.Errorf("source `%s` does not match the target address family", source)
+		}
+		return ip, nil
+	}
+
+	iface, err := net.InterfaceByName(source)
+	if err != nil {
+		return nil, fmt.Errorf("source `%s` is not an address or interface", source)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() != nil) != isIPv4 {
+			continue
+		}
+		if !isIPv4 && ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return ipNet.IP, nil
+	}
+	return nil, fmt.Errorf("interface `%s` has no address for the target address family", source)
+}
+
+// ipString returns the string form of an address or an empty string when the
+// address is not set
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// bindSource will bind a socket to a probe source before it is connected, when
+// the source is an interface the socket is also bound to the interface where
+// it is supported
+func bindSource(fd uintptr, source string, isIPv4 bool) error {
+	ip, err := sourceIP(source, isIPv4)
+	if err != nil {
+		return err
+	}
+
+	var sa syscall.Sockaddr
+	if isIPv4 {
+		sa4 := &syscall.SockaddrInet4{}
+		copy(sa4.Addr[:], ip.To4())
+		sa = sa4
+	} else {
+		sa6 := &syscall.SockaddrInet6{}
+		copy(sa6.Addr[:], ip.To16())
+		sa = sa6
+	}
+	if err := bindAddr(fd, sa); err != nil {
+		return err
+	}
+
+	if net.ParseIP(source) == nil {
+		return bindDevice(fd, source)
+	}
+	return nil
+}
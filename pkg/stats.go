@@ -0,0 +1,151 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+)
+
+// Sample holds the outcome of a single probe sent to a target, the error is
+// only set for probes which failed with a known reason. Probes which can
+// detect duplicate and reordered replies also set these.
+type Sample struct {
+	RTT       time.Duration
+	Recv      bool
+	Err       error
+	Dups      int  // Replies received after the first reply
+	Reordered bool // Reply received after the reply to a later probe
+}
+
+// NewResult will build a result from the samples collected from a target, the
+// samples must be in the order that the probes were sent
+func NewResult(t config.Target, samples []Sample) Result {
+	rtts := make([]time.Duration, 0, len(samples))
+	maxLoss, loss := 0, 0
+	var lastErr error
+	dups, reordered := 0, 0
+	for _, s := range samples {
+		dups += s.Dups
+		if s.Reordered {
+			reordered++
+		}
+		if !s.Recv {
+			if s.Err != nil {
+				lastErr = s.Err
+			}
+			loss++
+			if loss > maxLoss {
+				maxLoss = loss
+			}
+			continue
+		}
+		loss = 0
+		rtts = append(rtts, s.RTT)
+	}
+
+	sorted := make([]time.Duration, len(rtts))
+	copy(sorted, rtts)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	result := Result{
+		Target:  t,
+		Status:  StatusOK,
+		RTT:     averageRTT(rtts),
+		JTT:     calculateJitter(rtts),
+		Sent:    len(samples),
+		Drops:   len(samples) - len(rtts),
+		StdDev:  calculateStdDev(rtts),
+		P50:     percentile(sorted, 50),
+		P95:     percentile(sorted, 95),
+		P99:     percentile(sorted, 99),
+		MaxLoss: maxLoss,
+		Dups:    dups,
+		Reorder: reordered,
+	}
+	if t.Jitter == config.JitterRFC3550 {
+		result.JTT = calculateRFC3550Jitter(rtts)
+	}
+
+	ipdv := calculateIPDV(samples)
+	result.IPDV50 = percentile(ipdv, 50)
+	result.IPDV95 = percentile(ipdv, 95)
+	result.IPDV99 = percentile(ipdv, 99)
+
+	if len(sorted) > 0 {
+		result.Min = durationToMs(sorted[0])
+		result.Max = durationToMs(sorted[len(sorted)-1])
+		loss := float64(result.Drops) / float64(result.Sent) * 100
+		result.RFactor = calculateRFactor(result.RTT, result.JTT, loss)
+	} else {
+		// Probes without an error have timed out
+		result.Status = StatusTimeout
+		if lastErr != nil {
+			result.Status = classifyError(lastErr)
+		}
+	}
+	result.MOS = calculateMOS(result.RFactor)
+	return result
+}
+
+// durationToMs converts a duration into milliseconds
+func durationToMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// calculateStdDev in milliseconds from supplied slice of RTT's
+func calculateStdDev(rtts []time.Duration) float64 {
+	if len(rtts) == 0 {
+		return 0
+	}
+	mean := averageRTT(rtts)
+	var sumSquares float64
+	for _, rtt := range rtts {
+		diff := durationToMs(rtt) - mean
+		sumSquares += diff * diff
+	}
+	return math.Sqrt(sumSquares / float64(len(rtts)))
+}
+
+// percentile in milliseconds from supplied slice of sorted RTT's using the
+// nearest rank method
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return durationToMs(sorted[rank-1])
+}
+
+// calculateRFC3550Jitter in milliseconds from supplied slice of RTT's using
+// the smoothed interarrival jitter estimator from RFC 3550 section 6.4.1
+func calculateRFC3550Jitter(rtts []time.Duration) float64 {
+	var jitter float64
+	for i := 1; i < len(rtts); i++ {
+		diff := math.Abs(float64(rtts[i] - rtts[i-1]))
+		jitter += (diff - jitter) / 16
+	}
+	return jitter / float64(time.Millisecond)
+}
+
+// calculateIPDV returns the sorted RTT variation between each pair of
+// successive probes which both received a reply, as described in RFC 5481.
+// The sign is kept so a variation is negative when the RTT has decreased.
+func calculateIPDV(samples []Sample) []time.Duration {
+	ipdv := make([]time.Duration, 0, len(samples))
+	for i := 1; i < len(samples); i++ {
+		if !samples[i].Recv || !samples[i-1].Recv {
+			continue
+		}
+		ipdv = append(ipdv, samples[i].RTT-samples[i-1].RTT)
+	}
+	sort.Slice(ipdv, func(i, j int) bool { return ipdv[i] < ipdv[j] })
+	return ipdv
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+)
+
+// ms returns a duration of milliseconds
+func ms(n float64) time.Duration {
+	return time.Duration(n * float64(time.Millisecond))
+}
+
+// approxEqual checks if two floats are equal within a small tolerance
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		p      float64
+		want   float64
+	}{
+		{"no samples", nil, 50, 0},
+		{"no samples p99", []time.Duration{}, 99, 0},
+		{"one sample p50", []time.Duration{ms(7)}, 50, 7},
+		{"one sample p99", []time.Duration{ms(7)}, 99, 7},
+		{"one sample p0", []time.Duration{ms(7)}, 0, 7},
+		{"two samples p50", []time.Duration{ms(1), ms(2)}, 50, 1},
+		{"two samples p95", []time.Duration{ms(1), ms(2)}, 95, 2},
+		{"ten samples p50", []time.Duration{ms(1), ms(2), ms(3), ms(4), ms(5), ms(6), ms(7), ms(8), ms(9), ms(10)}, 50, 5},
+		{"ten samples p95", []time.Duration{ms(1), ms(2), ms(3), ms(4), ms(5), ms(6), ms(7), ms(8), ms(9), ms(10)}, 95, 10},
+		{"ten samples p100", []time.Duration{ms(1), ms(2), ms(3), ms(4), ms(5), ms(6), ms(7), ms(8), ms(9), ms(10)}, 100, 10},
+		{"negative values", []time.Duration{ms(-3), ms(-1), ms(2)}, 50, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); !approxEqual(got, tt.want) {
+				t.Errorf("percentile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateStdDev(t *testing.T) {
+	tests := []struct {
+		name string
+		rtts []time.Duration
+		want float64
+	}{
+		{"no samples", nil, 0},
+		{"one sample", []time.Duration{ms(5)}, 0},
+		{"constant", []time.Duration{ms(5), ms(5), ms(5)}, 0},
+		{"spread", []time.Duration{ms(2), ms(4), ms(4), ms(4), ms(5), ms(5), ms(7), ms(9)}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calculateStdDev(tt.rtts); !approxEqual(got, tt.want) {
+				t.Errorf("calculateStdDev() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateRFC3550Jitter(t *testing.T) {
+	tests := []struct {
+		name string
+		rtts []time.Duration
+		want float64
+	}{
+		{"no samples", nil, 0},
+		{"one sample", []time.Duration{ms(10)}, 0},
+		{"constant", []time.Duration{ms(10), ms(10), ms(10)}, 0},
+		{"one step", []time.Duration{ms(10), ms(26)}, 1},
+		{"step down", []time.Duration{ms(26), ms(10)}, 1},
+		{"two steps", []time.Duration{ms(10), ms(26), ms(10)}, 1 + 15.0/16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calculateRFC3550Jitter(tt.rtts); !approxEqual(got, tt.want) {
+				t.Errorf("calculateRFC3550Jitter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateIPDV(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []Sample
+		want    []time.Duration
+	}{
+		{"no samples", nil, []time.Duration{}},
+		{"one sample", []Sample{{RTT: ms(10), Recv: true}}, []time.Duration{}},
+		{
+			name:    "signed variation",
+			samples: []Sample{{RTT: ms(10), Recv: true}, {RTT: ms(15), Recv: true}, {RTT: ms(12), Recv: true}},
+			want:    []time.Duration{ms(-3), ms(5)},
+		},
+		{
+			name:    "lost probes are skipped",
+			samples: []Sample{{RTT: ms(10), Recv: true}, {}, {RTT: ms(15), Recv: true}, {RTT: ms(11), Recv: true}},
+			want:    []time.Duration{ms(-4)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calculateIPDV(tt.samples); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("calculateIPDV() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewResult(t *testing.T) {
+	target := config.Target{Name: "gw", Scheme: "icmp", Address: "10.0.0.1"}
+	tests := []struct {
+		name        string
+		samples     []Sample
+		wantStatus  string
+		wantSent    int
+		wantDrops   int
+		wantMaxLoss int
+		wantRTT     float64
+		wantP95     float64
+	}{
+		{"no samples", nil, StatusTimeout, 0, 0, 0, 0, 0},
+		{"one reply", []Sample{{RTT: ms(4), Recv: true}}, StatusOK, 1, 0, 0, 4, 4},
+		{"all lost", []Sample{{}, {}, {}}, StatusTimeout, 3, 3, 3, 0, 0},
+		{"unreachable", []Sample{{Err: errUnreachable}, {Err: errUnreachable}}, StatusUnreachable, 2, 2, 2, 0, 0},
+		{"permission denied", []Sample{{Err: errors.New("broken")}, {Err: fmt.Errorf("socket: %w", os.ErrPermission)}}, StatusPermissionDenied, 2, 2, 2, 0, 0},
+		{
+			name:        "longest loss run",
+			samples:     []Sample{{}, {RTT: ms(2), Recv: true}, {}, {}, {RTT: ms(4), Recv: true}},
+			wantStatus:  StatusOK,
+			wantSent:    5,
+			wantDrops:   3,
+			wantMaxLoss: 2,
+			wantRTT:     3,
+			wantP95:     4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewResult(target, tt.samples)
+			if got.Status != tt.wantStatus {
+				t.Errorf("NewResult() status = %s, want %s", got.Status, tt.wantStatus)
+			}
+			if got.Sent != tt.wantSent || got.Drops != tt.wantDrops || got.MaxLoss != tt.wantMaxLoss {
+				t.Errorf("NewResult() sent, drops, max loss = %d, %d, %d, want %d, %d, %d",
+					got.Sent, got.Drops, got.MaxLoss, tt.wantSent, tt.wantDrops, tt.wantMaxLoss)
+			}
+			if !approxEqual(got.RTT, tt.wantRTT) {
+				t.Errorf("NewResult() RTT = %v, want %v", got.RTT, tt.wantRTT)
+			}
+			if !approxEqual(got.P95, tt.wantP95) {
+				t.Errorf("NewResult() P95 = %v, want %v", got.P95, tt.wantP95)
+			}
+		})
+	}
+}
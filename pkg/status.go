@@ -0,0 +1,74 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+)
+
+// Probe status values reported in the `_STATUS` column
+const (
+	StatusOK               = "ok"                // At least one reply was received
+	StatusInvalid          = "invalid"           // Target config or address is not valid
+	StatusResolveFailed    = "resolve_failed"    // Target address could not be resolved
+	StatusUnreachable      = "unreachable"       // Target or network reported as unreachable
+	StatusTimeout          = "timeout"           // No replies were received in time
+	StatusPermissionDenied = "permission_denied" // Sockets could not be opened
+	StatusStarting         = "starting"          // Continuous session has no results yet
+)
+
+// errUnreachable is used for probes which received an unreachable reply
+var errUnreachable = errors.New("destination unreachable")
+
+// errInvalid is used for targets which cannot be tested as they are not valid
+var errInvalid = errors.New("target is not valid")
+
+// noSuitableAddress is the address error returned when a target has no
+// address of the family which is required
+const noSuitableAddress = "no suitable address found"
+
+// classifyError will return the status for the error of a failed probe
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	var addrErr *net.AddrError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return StatusOK
+	case errors.Is(err, errInvalid):
+		return StatusInvalid
+	case errors.As(err, &dnsErr):
+		return StatusResolveFailed
+	case errors.As(err, &addrErr):
+		// Other address errors are for malformed addresses such as a
+		// missing port
+		if addrErr.Err == noSuitableAddress {
+			return StatusResolveFailed
+		}
+		return StatusInvalid
+	case errors.Is(err, os.ErrPermission),
+		errors.Is(err, syscall.EPERM),
+		errors.Is(err, syscall.EACCES):
+		return StatusPermissionDenied
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return StatusTimeout
+	}
+	return StatusUnreachable
+}
+
+// failedResult will create the result for a target which could not be tested
+func failedResult(t config.Target, err error) Result {
+	return Result{
+		Target: t,
+		Status: classifyError(err),
+	}
+}
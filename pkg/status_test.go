@@ -0,0 +1,41 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"no error", nil, StatusOK},
+		{"dns", &net.DNSError{Err: "no such host", Name: "nx.example.com", IsNotFound: true}, StatusResolveFailed},
+		{"no address of family", &net.AddrError{Err: noSuitableAddress, Addr: "example.com"}, StatusResolveFailed},
+		{"missing port", &net.AddrError{Err: "missing port in address", Addr: "db01"}, StatusInvalid},
+		{"invalid target", fmt.Errorf("%w: unsupported scheme `tpc`", errInvalid), StatusInvalid},
+		{"permission", fmt.Errorf("socket: %w", os.ErrPermission), StatusPermissionDenied},
+		{"eperm", &net.OpError{Op: "dial", Err: syscall.EPERM}, StatusPermissionDenied},
+		{"deadline", context.DeadlineExceeded, StatusTimeout},
+		{"unreachable", errUnreachable, StatusUnreachable},
+		{"refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, StatusUnreachable},
+		{"other", errors.New("broken"), StatusUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
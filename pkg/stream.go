@@ -0,0 +1,237 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+// icmpSeqRange is the number of sequences before the echo sequence wraps
+const icmpSeqRange int = 1 << 16
+
+// Streams holds continuous ICMP sessions which send echo requests to each
+// target at a steady rate. The statistics collected by a session are returned
+// each time it is flushed, so outages between tests are not missed. Sessions
+// of targets which are not flushed by a run are stopped, so a Streams should
+// only be used by a single caller.
+type Streams struct {
+	mu       sync.Mutex
+	sessions map[string]*icmpStream
+	flushed  map[string]bool // Sessions flushed since the last sweep
+}
+
+// NewStreams is used to create an empty set of continuous sessions
+func NewStreams() *Streams {
+	return &Streams{
+		sessions: make(map[string]*icmpStream),
+		flushed:  make(map[string]bool),
+	}
+}
+
+// Close will stop all sessions
+func (s *Streams) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, session := range s.sessions {
+		session.close()
+		delete(s.sessions, key)
+	}
+}
+
+// sweep will stop the sessions which have not been flushed since the last
+// sweep, these belong to targets which have been removed or changed
+func (s *Streams) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, session := range s.sessions {
+		if !s.flushed[key] {
+			session.close()
+			delete(s.sessions, key)
+		}
+	}
+	s.flushed = make(map[string]bool)
+}
+
+// flush will return the result for a target since the last flush, a session
+// is started for targets which do not have one
+func (s *Streams) flush(t config.Target, privileged bool) (Result, error) {
+	key := streamKey(t)
+
+	s.mu.Lock()
+	s.flushed[key] = true
+	session, ok := s.sessions[key]
+	if !ok {
+		// Results are returned from the next flush
+		var err error
+		session, err = newICMPStream(t, privileged)
+		if err != nil {
+			s.mu.Unlock()
+			return Result{}, err
+		}
+		s.sessions[key] = session
+		log.Debug().Msgf("Started continuous session to target `%s`", t.Name)
+		s.mu.Unlock()
+		return Result{Target: t, Status: StatusStarting}, nil
+	}
+	s.mu.Unlock()
+
+	result := NewResult(t, session.flush(time.Now()))
+	result.IP = session.pinger.dst.IP.String()
+	return result, nil
+}
+
+// streamKey is used to identify a session, any change to the target options
+// will start a new session
+func streamKey(t config.Target) string {
+	return fmt.Sprintf("%+v", t)
+}
+
+// streamProbe holds a single echo request sent by a session
+type streamProbe struct {
+	num    uint64 // Number of requests sent before this one
+	sentAt time.Time
+	sample Sample
+}
+
+// icmpStream sends echo requests to a target until it is closed
+type icmpStream struct {
+	pinger *icmpPinger
+	sock   *engineSocket
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	pending []*streamProbe       // Requests in the order they were sent
+	bySeq   map[int]*streamProbe // Requests which have not been flushed
+	maxNum  uint64               // Latest request which had a reply
+	anyRecv bool                 // A reply has been received
+}
+
+// newICMPStream will start sending to a target
+func newICMPStream(t config.Target, privileged bool) (*icmpStream, error) {
+	pinger, err := newTargetPinger(t, privileged)
+	if err != nil {
+		return nil, err
+	}
+	pinger.count = icmpSeqRange
+	if t.Size > 0 {
+		pinger.size = t.Size
+	}
+
+	s := &icmpStream{
+		pinger: pinger,
+		done:   make(chan struct{}),
+		bySeq:  make(map[int]*streamProbe),
+	}
+	s.sock, err = engine.register(pinger, s)
+	if err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.sendLoop()
+	return s, nil
+}
+
+// close will stop sending and wait for the session to finish
+func (s *icmpStream) close() {
+	close(s.done)
+	s.wg.Wait()
+	engine.unregister(s.sock, s.pinger)
+}
+
+// sendLoop will send an echo request each interval until the session is closed
+func (s *icmpStream) sendLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.pinger.interval)
+	defer ticker.Stop()
+
+	for num := uint64(0); ; num++ {
+		seq := int(num % uint64(icmpSeqRange))
+		probe := &streamProbe{num: num, sentAt: time.Now()}
+
+		s.mu.Lock()
+		// A request which has not been flushed after the sequence wrapped
+		// can no longer be matched to a reply
+		delete(s.bySeq, seq)
+		s.pending = append(s.pending, probe)
+		s.bySeq[seq] = probe
+		s.mu.Unlock()
+
+		if err := s.pinger.send(s.sock.conn, seq); err != nil {
+			log.Debug().Msgf("Continuous ping error: %s", err)
+			s.mu.Lock()
+			probe.sample.Err = err
+			s.mu.Unlock()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// reply will record the reply to a request
+func (s *icmpStream) reply(seq int, rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	probe, ok := s.bySeq[seq]
+	if !ok {
+		return
+	}
+	if probe.sample.Recv {
+		probe.sample.Dups++
+		return
+	}
+
+	probe.sample.RTT, probe.sample.Recv, probe.sample.Err = rtt, true, nil
+	if s.anyRecv && probe.num < s.maxNum {
+		probe.sample.Reordered = true
+	} else {
+		s.maxNum, s.anyRecv = probe.num, true
+	}
+}
+
+// unreachable will record an unreachable error for a request
+func (s *icmpStream) unreachable(seq int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if probe, ok := s.bySeq[seq]; ok && !probe.sample.Recv {
+		probe.sample.Err = errUnreachable
+	}
+}
+
+// flush will return the samples of all requests which have a reply or have
+// timed out, requests still waiting for a reply are kept for the next flush
+func (s *icmpStream) flush(now time.Time) []Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	samples := make([]Sample, 0, len(s.pending))
+	for len(s.pending) > 0 {
+		probe := s.pending[0]
+		if !probe.sample.Recv && now.Sub(probe.sentAt) < s.pinger.timeout {
+			break
+		}
+		samples = append(samples, probe.sample)
+
+		seq := int(probe.num % uint64(icmpSeqRange))
+		if s.bySeq[seq] == probe {
+			delete(s.bySeq, seq)
+		}
+		s.pending[0] = nil
+		s.pending = s.pending[1:]
+	}
+	return samples
+}
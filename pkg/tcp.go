@@ -0,0 +1,53 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	Register("tcp", tcpProber{})
+}
+
+// tcpProber tests targets using TCP connects, the time taken to complete the
+// handshake is used as the RTT
+type tcpProber struct{}
+
+// Probe will run a single TCP connect test to a target
+func (tcpProber) Probe(ctx context.Context, t config.Target, opts Options) (Result, error) {
+	if _, _, err := net.SplitHostPort(t.Address); err != nil {
+		return Result{}, err
+	}
+
+	dialer := newDialer(t)
+	ip := ""
+	samples := Series(ctx, t, func(seq int) (time.Duration, error) {
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, targetNetwork(t, "tcp"), dialAddress(t, t.Address))
+		if err != nil {
+			log.Debug().Msgf("TCP connect to `%s` failed: %s", t.Name, err)
+			return 0, err
+		}
+		rtt := time.Since(start)
+		ip = remoteIP(conn)
+		conn.Close()
+		return rtt, nil
+	})
+
+	result := NewResult(t, samples)
+	result.IP = ip
+	return result, nil
+}
+
+// Metrics returns no additional metrics for TCP targets
+func (tcpProber) Metrics(t config.Target) []string {
+	return nil
+}
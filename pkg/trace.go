@@ -0,0 +1,332 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	traceMaxHops  int = 30    // Maximum TTL used when discovering hops
+	traceMaxQuiet int = 3     // Hops in a row without a reply before giving up
+	traceBasePort int = 33434 // First destination port used by UDP probes
+)
+
+// traceHop is used as the hop address when a hop does not respond
+const traceHop = "*"
+
+// traceProbe holds the details needed to send and match the probes of a trace
+type traceProbe struct {
+	method string
+	dst    *net.IPAddr
+	ipv4   bool
+	id     int
+	port   int // Source port of UDP probes
+	conn   net.PacketConn
+	udp    net.PacketConn
+}
+
+// traceTarget will discover the path to a target by sending probes with an
+// increasing TTL and return the address of each hop. The method can be `icmp`
+// to send echo requests or `udp` to send datagrams to high ports, both require
+// raw socket access to receive the replies from each hop. The trace stops when
+// several hops in a row do not respond or a hop reports the target is
+// unreachable, false is then returned as the target was not reached.
+func traceTarget(ctx context.Context, t config.Target, method string, timeout time.Duration) ([]string, bool, error) {
+	host, err := targetHost(t)
+	if err != nil {
+		return nil, false, err
+	}
+	if t.IP != "" {
+		host = t.IP
+	}
+
+	dst, err := net.ResolveIPAddr(targetNetwork(t, "ip"), host)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The ID is shared with the pingers so replies to them are not matched
+	id, _ := newPingerID()
+	probe := &traceProbe{
+		method: method,
+		dst:    dst,
+		ipv4:   dst.IP.To4() != nil,
+		id:     id,
+	}
+
+	switch method {
+	case config.TraceICMP, config.TraceUDP:
+	default:
+		return nil, false, fmt.Errorf("unsupported trace method `%s`", method)
+	}
+
+	// Sockets are opened from inside the target network namespace and bound
+	// to the target VRF
+	lc := net.ListenConfig{Control: vrfControl(t.VRF)}
+	err = inNetns(t.Netns, func() error {
+		var src net.IP
+		var err error
+		if t.Source != "" {
+			src, err = sourceIP(t.Source, probe.ipv4)
+			if err != nil {
+				return err
+			}
+		}
+
+		network, udpNetwork := "ip4:icmp", "udp4"
+		if !probe.ipv4 {
+			network, udpNetwork = "ip6:ipv6-icmp", "udp6"
+		}
+		probe.conn, err = lc.ListenPacket(ctx, network, ipString(src))
+		if err != nil || method != "udp" {
+			return err
+		}
+		probe.udp, err = lc.ListenPacket(ctx, udpNetwork, net.JoinHostPort(ipString(src), "0"))
+		if err != nil {
+			probe.conn.Close()
+			return err
+		}
+		probe.port = probe.udp.LocalAddr().(*net.UDPAddr).Port
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	defer probe.conn.Close()
+	if probe.udp != nil {
+		defer probe.udp.Close()
+	}
+
+	path := make([]string, 0)
+	quiet := 0
+	for ttl := 1; ttl <= traceMaxHops && quiet < traceMaxQuiet; ttl++ {
+		if ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+		hop, done, reached, err := probe.send(ttl, timeout)
+		if err != nil {
+			return nil, false, err
+		}
+		path = append(path, hop)
+		if done {
+			return path, reached, nil
+		}
+		if hop == traceHop {
+			quiet++
+		} else {
+			quiet = 0
+		}
+	}
+
+	// Hops after the last hop which responded are not part of the path
+	for len(path) > 0 && path[len(path)-1] == traceHop {
+		path = path[:len(path)-1]
+	}
+	return path, false, nil
+}
+
+// send will send a single probe with the supplied TTL and wait for a reply.
+// It returns the hop address, true when the trace is done as the reply is an
+// echo reply or unreachable, and true when the destination was reached.
+func (p *traceProbe) send(ttl int, timeout time.Duration) (string, bool, bool, error) {
+	var err error
+	switch p.method {
+	case "icmp":
+		err = p.sendEcho(ttl)
+	case "udp":
+		err = p.sendUDP(ttl)
+	}
+	if err != nil {
+		return "", false, false, err
+	}
+
+	proto := 1 // ICMP
+	if !p.ipv4 {
+		proto = 58 // ICMPv6
+	}
+
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 1500)
+	for {
+		p.conn.SetReadDeadline(deadline)
+		n, peer, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+				return traceHop, false, false, nil
+			}
+			return "", false, false, err
+		}
+
+		msg, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		hop := peerIP(peer)
+		if matched, done, reached := p.classify(msg, hop, ttl); matched {
+			return hop, done, reached, nil
+		}
+	}
+}
+
+// classify checks if an ICMP message from a hop is the reply to the probe
+// sent with the supplied TTL. Matched replies finish the trace when they are
+// an echo reply or unreachable, the destination is only reached when the
+// reply is from it and for UDP probes when the port is unreachable.
+func (p *traceProbe) classify(msg *icmp.Message, hop string, ttl int) (matched, done, reached bool) {
+	fromDst := p.dst.IP.Equal(net.ParseIP(hop))
+	switch body := msg.Body.(type) {
+	case *icmp.Echo:
+		if p.method == "icmp" && fromDst && body.ID == p.id && body.Seq == ttl &&
+			(msg.Type == ipv4.ICMPTypeEchoReply || msg.Type == ipv6.ICMPTypeEchoReply) {
+			return true, true, true
+		}
+	case *icmp.TimeExceeded:
+		if p.matches(body.Data, ttl) {
+			return true, false, false
+		}
+	case *icmp.DstUnreach:
+		if !p.matches(body.Data, ttl) {
+			return false, false, false
+		}
+		if p.method == "udp" {
+			portUnreachable := msg.Type == ipv4.ICMPTypeDestinationUnreachable && msg.Code == 3 ||
+				msg.Type == ipv6.ICMPTypeDestinationUnreachable && msg.Code == 4
+			return true, true, fromDst && portUnreachable
+		}
+		return true, true, fromDst
+	}
+	return false, false, false
+}
+
+// sendEcho will send an ICMP echo request with the TTL used as the sequence
+func (p *traceProbe) sendEcho(ttl int) error {
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if p.ipv4 {
+		if err := ipv4.NewPacketConn(p.conn).SetTTL(ttl); err != nil {
+			return err
+		}
+	} else {
+		typ = ipv6.ICMPTypeEchoRequest
+		if err := ipv6.NewPacketConn(p.conn).SetHopLimit(ttl); err != nil {
+			return err
+		}
+	}
+
+	msg := icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{
+			ID:   p.id,
+			Seq:  ttl,
+			Data: []byte("pingsheet"),
+		},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	_, err = p.conn.WriteTo(b, p.dst)
+	return err
+}
+
+// sendUDP will send a UDP datagram to a port derived from the TTL
+func (p *traceProbe) sendUDP(ttl int) error {
+	if p.ipv4 {
+		if err := ipv4.NewPacketConn(p.udp).SetTTL(ttl); err != nil {
+			return err
+		}
+	} else {
+		if err := ipv6.NewPacketConn(p.udp).SetHopLimit(ttl); err != nil {
+			return err
+		}
+	}
+
+	dst := &net.UDPAddr{IP: p.dst.IP, Zone: p.dst.Zone, Port: traceBasePort + ttl}
+	_, err := p.udp.WriteTo([]byte("pingsheet"), dst)
+	return err
+}
+
+// matches checks if the original datagram quoted in an ICMP error is the
+// probe sent with the supplied TTL
+func (p *traceProbe) matches(data []byte, ttl int) bool {
+	var proto byte
+	var payload []byte
+	if p.ipv4 {
+		if len(data) < ipv4.HeaderLen {
+			return false
+		}
+		hdrLen := int(data[0]&0x0f) * 4
+		if len(data) < hdrLen+8 {
+			return false
+		}
+		proto = data[9]
+		payload = data[hdrLen:]
+	} else {
+		if len(data) < ipv6.HeaderLen+8 {
+			return false
+		}
+		proto = data[6]
+		payload = data[ipv6.HeaderLen:]
+	}
+
+	switch p.method {
+	case "icmp":
+		if proto != 1 && proto != 58 {
+			return false
+		}
+		id := int(binary.BigEndian.Uint16(payload[4:6]))
+		seq := int(binary.BigEndian.Uint16(payload[6:8]))
+		return id == p.id && seq == ttl
+	case "udp":
+		if proto != 17 {
+			return false
+		}
+		srcPort := int(binary.BigEndian.Uint16(payload[0:2]))
+		dstPort := int(binary.BigEndian.Uint16(payload[2:4]))
+		return srcPort == p.port && dstPort == traceBasePort+ttl
+	}
+	return false
+}
+
+// targetHost will return the host part of a target address for any scheme
+func targetHost(t config.Target) (string, error) {
+	switch t.Scheme {
+	case "icmp":
+		return t.Address, nil
+	case "tcp":
+		host, _, err := net.SplitHostPort(t.Address)
+		return host, err
+	default:
+		u, err := url.Parse(t.URL())
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", errInvalid, err)
+		}
+		if u.Hostname() == "" {
+			return "", fmt.Errorf("%w: target host is missing", errInvalid)
+		}
+		return u.Hostname(), nil
+	}
+}
+
+// peerIP returns the IP address of an ICMP peer
+func peerIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	}
+	return addr.String()
+}
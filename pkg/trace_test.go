@@ -0,0 +1,141 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// quotedIPv4 returns an IPv4 header with the protocol followed by the first 8
+// bytes of a datagram, as quoted in ICMP errors
+func quotedIPv4(proto byte, words ...uint16) []byte {
+	b := make([]byte, ipv4.HeaderLen+8)
+	b[0], b[9] = 0x45, proto
+	for i, w := range words {
+		binary.BigEndian.PutUint16(b[ipv4.HeaderLen+i*2:], w)
+	}
+	return b
+}
+
+func TestTraceProbeClassify(t *testing.T) {
+	const (
+		dst    = "198.51.100.7"
+		router = "192.0.2.1"
+		id     = 4321
+		port   = 40000
+		ttl    = 5
+	)
+	echoQuote := quotedIPv4(1, 0x0800, 0, id, ttl)
+	udpQuote := quotedIPv4(17, port, uint16(traceBasePort+ttl))
+
+	tests := []struct {
+		name        string
+		method      string
+		msg         icmp.Message
+		hop         string
+		wantMatched bool
+		wantDone    bool
+		wantReached bool
+	}{
+		{
+			name:        "echo reply from destination",
+			method:      "icmp",
+			msg:         icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: ttl}},
+			hop:         dst,
+			wantMatched: true, wantDone: true, wantReached: true,
+		},
+		{
+			name:   "echo reply from another host",
+			method: "icmp",
+			msg:    icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: ttl}},
+			hop:    router,
+		},
+		{
+			name:   "echo reply with another sequence",
+			method: "icmp",
+			msg:    icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: ttl + 1}},
+			hop:    dst,
+		},
+		{
+			name:   "echo reply with another ID",
+			method: "icmp",
+			msg:    icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id + 1, Seq: ttl}},
+			hop:    dst,
+		},
+		{
+			name:        "time exceeded",
+			method:      "icmp",
+			msg:         icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: echoQuote}},
+			hop:         router,
+			wantMatched: true,
+		},
+		{
+			name:   "time exceeded for another probe",
+			method: "icmp",
+			msg:    icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quotedIPv4(1, 0x0800, 0, id, ttl+1)}},
+			hop:    router,
+		},
+		{
+			name:        "host unreachable from router",
+			method:      "icmp",
+			msg:         icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 1, Body: &icmp.DstUnreach{Data: echoQuote}},
+			hop:         router,
+			wantMatched: true, wantDone: true,
+		},
+		{
+			name:        "udp port unreachable from destination",
+			method:      "udp",
+			msg:         icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 3, Body: &icmp.DstUnreach{Data: udpQuote}},
+			hop:         dst,
+			wantMatched: true, wantDone: true, wantReached: true,
+		},
+		{
+			name:        "udp port unreachable from router",
+			method:      "udp",
+			msg:         icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 3, Body: &icmp.DstUnreach{Data: udpQuote}},
+			hop:         router,
+			wantMatched: true, wantDone: true,
+		},
+		{
+			name:        "udp admin prohibited from destination",
+			method:      "udp",
+			msg:         icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 13, Body: &icmp.DstUnreach{Data: udpQuote}},
+			hop:         dst,
+			wantMatched: true, wantDone: true,
+		},
+		{
+			name:   "udp unreachable for another port",
+			method: "udp",
+			msg:    icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 3, Body: &icmp.DstUnreach{Data: quotedIPv4(17, port+1, uint16(traceBasePort+ttl))}},
+			hop:    dst,
+		},
+		{
+			name:   "echo reply to udp trace",
+			method: "udp",
+			msg:    icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: ttl}},
+			hop:    dst,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &traceProbe{
+				method: tt.method,
+				dst:    &net.IPAddr{IP: net.ParseIP(dst)},
+				ipv4:   true,
+				id:     id,
+				port:   port,
+			}
+			matched, done, reached := p.classify(&tt.msg, tt.hop, ttl)
+			if matched != tt.wantMatched || done != tt.wantDone || reached != tt.wantReached {
+				t.Errorf("classify() = %v, %v, %v, want %v, %v, %v",
+					matched, done, reached, tt.wantMatched, tt.wantDone, tt.wantReached)
+			}
+		})
+	}
+}
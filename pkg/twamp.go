@@ -0,0 +1,334 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// DefaultTWAMPPort is the UDP port used by `twamp://` targets which do not set
+// a port
+const DefaultTWAMPPort int = 862
+
+// TWAMP-light test packets use the unauthenticated formats from RFC 5357. The
+// sender packet holds the sequence, send timestamp and error estimate. The
+// reflector packet holds its own sequence, send timestamp and error estimate,
+// the receive timestamp and a copy of the sender fields and TTL.
+const (
+	twampSenderLen  int = 14
+	twampReflectLen int = 41
+
+	// Clock is not synchronised to UTC and has a multiplier of 1
+	twampErrorEstimate uint16 = 0x0001
+
+	// Seconds between the NTP epoch of 1900 and the Unix epoch
+	ntpEpochOffset int64 = 2208988800
+
+	// Reflector sessions which have not received a packet are removed
+	twampSessionTimeout = time.Minute
+
+	// Most senders the reflector keeps a session for at the same time
+	twampMaxSessions int = 4096
+)
+
+func init() {
+	Register("twamp", twampProber{})
+}
+
+// twampProber tests targets such as `twamp://10.0.0.1:862` by sending
+// TWAMP-light test packets to a reflector. The timestamps of the reflector are
+// used to split the RTT into the forward and reverse one-way delay, which
+// requires the clocks of both hosts to be synchronised.
+type twampProber struct{}
+
+// Probe will run a single TWAMP-light test to a target
+func (twampProber) Probe(ctx context.Context, t config.Target, opts Options) (Result, error) {
+	conn, err := newDialer(t).DialContext(ctx, targetNetwork(t, "udp"), dialAddress(t, withPort(t, DefaultTWAMPPort)))
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	// Senders pad to the size of the reflector packet so both directions use
+	// the same packet size
+	size := twampReflectLen
+	if t.Size > size {
+		size = t.Size
+	}
+
+	tr := &twampReplies{
+		count: t.Count,
+		fwd:   make(map[int]time.Duration),
+		rev:   make(map[int]time.Duration),
+	}
+	samples, err := exchangeUDP(ctx, t, conn,
+		func(seq int) []byte { return newTWAMPTest(seq, size) },
+		tr.parse,
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := NewResult(t, samples)
+	result.IP = remoteIP(conn)
+	result.Extra = tr.metrics(len(samples))
+	return result, nil
+}
+
+// Metrics returns the one-way delay and loss metrics for each direction
+func (twampProber) Metrics(t config.Target) []string {
+	return []string{"FWD", "REV", "FWDDROPS", "REVDROPS"}
+}
+
+// newTWAMPTest will create a sender test packet with the send time
+func newTWAMPTest(seq, size int) []byte {
+	b := make([]byte, size)
+	binary.BigEndian.PutUint32(b[0:4], uint32(seq))
+	binary.BigEndian.PutUint16(b[12:14], twampErrorEstimate)
+	binary.BigEndian.PutUint64(b[4:12], toNTP(time.Now()))
+	return b
+}
+
+// twampReplies holds the one-way delays of the first reply to each sequence,
+// and the highest reflector sequence received which shows how many packets
+// the reflector sent
+type twampReplies struct {
+	count int
+
+	mu      sync.Mutex
+	fwd     map[int]time.Duration
+	rev     map[int]time.Duration
+	maxSeq  uint32
+	anyRecv bool
+}
+
+// reply will record the one-way delays of a reply
+func (tr *twampReplies) reply(seq int, reflectSeq uint32, fwd, rev time.Duration) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if seq >= tr.count {
+		return
+	}
+	if _, ok := tr.fwd[seq]; ok {
+		return
+	}
+	tr.fwd[seq], tr.rev[seq] = fwd, rev
+
+	if !tr.anyRecv || reflectSeq > tr.maxSeq {
+		tr.maxSeq = reflectSeq
+	}
+	tr.anyRecv = true
+}
+
+// metrics returns the average one-way delays in milliseconds and the packets
+// lost in each direction. Reflector sequences start at zero for each sender,
+// so the packets it sent are counted up to the highest sequence received and
+// losses after the last reply are counted as forward losses.
+func (tr *twampReplies) metrics(sent int) map[string]interface{} {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if !tr.anyRecv {
+		return map[string]interface{}{"FWDDROPS": sent, "REVDROPS": 0}
+	}
+
+	var fwd, rev time.Duration
+	for seq := range tr.fwd {
+		fwd += tr.fwd[seq]
+		rev += tr.rev[seq]
+	}
+	recv := len(tr.fwd)
+	reflected := int(tr.maxSeq) + 1
+	if reflected > sent {
+		reflected = sent
+	}
+	if reflected < recv {
+		reflected = recv
+	}
+
+	return map[string]interface{}{
+		"FWD":      durationToMs(fwd / time.Duration(recv)),
+		"REV":      durationToMs(rev / time.Duration(recv)),
+		"FWDDROPS": sent - reflected,
+		"REVDROPS": reflected - recv,
+	}
+}
+
+// parse will record the one-way delays of a reflector packet and return the
+// sequence and RTT, false is returned for packets which are too short
+func (tr *twampReplies) parse(b []byte, received time.Time) (int, time.Duration, bool) {
+	if len(b) < twampReflectLen {
+		return 0, 0, false
+	}
+
+	reflectSeq := binary.BigEndian.Uint32(b[0:4])
+	reflectSent := fromNTP(binary.BigEndian.Uint64(b[4:12]))
+	reflectRecv := fromNTP(binary.BigEndian.Uint64(b[16:24]))
+	seq := int(binary.BigEndian.Uint32(b[24:28]))
+	sentAt := fromNTP(binary.BigEndian.Uint64(b[28:36]))
+
+	// The reflector times are from its own clock, so the RTT only uses the
+	// time between them
+	rtt := received.Sub(sentAt)
+	if held := reflectSent.Sub(reflectRecv); held > 0 && held < rtt {
+		rtt -= held
+	}
+	tr.reply(seq, reflectSeq, reflectRecv.Sub(sentAt), received.Sub(reflectSent))
+	return seq, rtt, true
+}
+
+// toNTP returns a time in the 64 bit NTP timestamp format
+func toNTP(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := (uint64(t.Nanosecond()) << 32) / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+// fromNTP returns the time of a 64 bit NTP timestamp
+func fromNTP(ts uint64) time.Time {
+	secs := int64(ts>>32) - ntpEpochOffset
+	nsecs := ((ts & 0xffffffff) * uint64(time.Second)) >> 32
+	return time.Unix(secs, int64(nsecs))
+}
+
+// TWAMPReflector answers TWAMP-light test packets from `twamp://` targets and
+// other TWAMP-light senders. The reflector keeps a session for each sender
+// so the reflector sequence shows how many packets it has sent. Test packets
+// shorter than the reply are not answered so the reflector cannot be used to
+// amplify traffic, and packets from new senders are not answered while the
+// reflector has the most sessions.
+type TWAMPReflector struct {
+	conn net.PacketConn
+	p4   *ipv4.PacketConn // Used to read the TTL of IPv4 only listeners
+	p6   *ipv6.PacketConn // Used to read the hop limit of other listeners
+
+	sessions map[string]*twampSession
+	purged   time.Time
+}
+
+// twampSession holds the state of a sender
+type twampSession struct {
+	seq  uint32
+	last time.Time
+}
+
+// NewTWAMPReflector is used to create a TWAMPReflector listening on an
+// address such as `:862`
+func NewTWAMPReflector(addr string) (*TWAMPReflector, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &TWAMPReflector{
+		conn:     conn,
+		sessions: make(map[string]*twampSession),
+		purged:   time.Now(),
+	}
+	// The sender TTL is left empty where it cannot be read
+	if local, ok := conn.LocalAddr().(*net.UDPAddr); ok && local.IP.To4() != nil {
+		r.p4 = ipv4.NewPacketConn(conn)
+		r.p4.SetControlMessage(ipv4.FlagTTL, true)
+	} else {
+		r.p6 = ipv6.NewPacketConn(conn)
+		r.p6.SetControlMessage(ipv6.FlagHopLimit, true)
+	}
+	return r, nil
+}
+
+// Addr returns the address the reflector is listening on
+func (r *TWAMPReflector) Addr() net.Addr {
+	return r.conn.LocalAddr()
+}
+
+// Serve will answer test packets until the reflector is closed
+func (r *TWAMPReflector) Serve() error {
+	buf := make([]byte, 65536)
+	for {
+		n, ttl, peer, err := r.read(buf)
+		received := time.Now()
+		if err != nil {
+			return err
+		}
+		if n < twampReflectLen {
+			continue
+		}
+
+		session, ok := r.session(peer.String(), received)
+		if !ok {
+			log.Debug().Msgf("TWAMP reflector has %d sessions, ignoring %s", twampMaxSessions, peer)
+			continue
+		}
+		reply := make([]byte, n)
+		binary.BigEndian.PutUint32(reply[0:4], session.seq)
+		binary.BigEndian.PutUint16(reply[12:14], twampErrorEstimate)
+		binary.BigEndian.PutUint64(reply[16:24], toNTP(received))
+		copy(reply[24:38], buf[:twampSenderLen])
+		reply[40] = byte(ttl)
+		session.seq++
+
+		binary.BigEndian.PutUint64(reply[4:12], toNTP(time.Now()))
+		if _, err := r.conn.WriteTo(reply, peer); err != nil {
+			log.Debug().Msgf("TWAMP reflector could not reply to %s: %s", peer, err)
+		}
+	}
+}
+
+// read will read a test packet along with the TTL it was received with
+func (r *TWAMPReflector) read(b []byte) (int, int, net.Addr, error) {
+	if r.p4 != nil {
+		n, cm, peer, err := r.p4.ReadFrom(b)
+		if cm != nil {
+			return n, cm.TTL, peer, err
+		}
+		return n, 0, peer, err
+	}
+	n, cm, peer, err := r.p6.ReadFrom(b)
+	if cm != nil {
+		return n, cm.HopLimit, peer, err
+	}
+	return n, 0, peer, err
+}
+
+// session returns the session of a sender, sessions which have timed out are
+// removed at most once per timeout, or once per second while there are too
+// many. False is returned when a new sender cannot be given a session.
+func (r *TWAMPReflector) session(peer string, now time.Time) (*twampSession, bool) {
+	full := len(r.sessions) >= twampMaxSessions
+	if now.Sub(r.purged) > twampSessionTimeout || full && now.Sub(r.purged) > time.Second {
+		for key, s := range r.sessions {
+			if now.Sub(s.last) > twampSessionTimeout {
+				delete(r.sessions, key)
+			}
+		}
+		r.purged = now
+	}
+
+	s, ok := r.sessions[peer]
+	if !ok {
+		if len(r.sessions) >= twampMaxSessions {
+			return nil, false
+		}
+		s = &twampSession{}
+		r.sessions[peer] = s
+	}
+	s.last = now
+	return s, true
+}
+
+// Close will stop the reflector
+func (r *TWAMPReflector) Close() error {
+	return r.conn.Close()
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+)
+
+func TestTWAMPRepliesMetrics(t *testing.T) {
+	// twampReply is a reply from the reflector with the one-way delays in
+	// milliseconds
+	type twampReply struct {
+		seq        int
+		reflectSeq uint32
+		fwd        float64
+		rev        float64
+	}
+	tests := []struct {
+		name    string
+		replies []twampReply
+		sent    int
+		want    map[string]interface{}
+	}{
+		{
+			name: "no reply",
+			sent: 3,
+			want: map[string]interface{}{"FWDDROPS": 3, "REVDROPS": 0},
+		},
+		{
+			name:    "all received",
+			replies: []twampReply{{0, 0, 1, 4}, {1, 1, 3, 4}, {2, 2, 2, 4}},
+			sent:    3,
+			want:    map[string]interface{}{"FWD": 2.0, "REV": 4.0, "FWDDROPS": 0, "REVDROPS": 0},
+		},
+		{
+			name:    "forward lost",
+			replies: []twampReply{{0, 0, 2, 2}, {2, 1, 2, 2}},
+			sent:    4,
+			want:    map[string]interface{}{"FWD": 2.0, "REV": 2.0, "FWDDROPS": 2, "REVDROPS": 0},
+		},
+		{
+			name:    "reverse lost",
+			replies: []twampReply{{1, 1, 2, 2}, {3, 3, 2, 2}},
+			sent:    4,
+			want:    map[string]interface{}{"FWD": 2.0, "REV": 2.0, "FWDDROPS": 0, "REVDROPS": 2},
+		},
+		{
+			name:    "lost in both directions",
+			replies: []twampReply{{0, 0, 2, 2}, {3, 2, 2, 2}, {4, 3, 2, 2}},
+			sent:    5,
+			want:    map[string]interface{}{"FWD": 2.0, "REV": 2.0, "FWDDROPS": 1, "REVDROPS": 1},
+		},
+		{
+			name:    "duplicate reply",
+			replies: []twampReply{{0, 0, 2, 2}, {0, 1, 8, 8}},
+			sent:    1,
+			want:    map[string]interface{}{"FWD": 2.0, "REV": 2.0, "FWDDROPS": 0, "REVDROPS": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &twampReplies{
+				count: tt.sent,
+				fwd:   make(map[int]time.Duration),
+				rev:   make(map[int]time.Duration),
+			}
+			for _, r := range tt.replies {
+				tr.reply(r.seq, r.reflectSeq, ms(r.fwd), ms(r.rev))
+			}
+			if got := tr.metrics(tt.sent); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("metrics() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTWAMPReflectorRoundTrip(t *testing.T) {
+	ref, err := NewTWAMPReflector("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewTWAMPReflector() error = %v", err)
+	}
+	defer ref.Close()
+	go ref.Serve()
+
+	target := config.Target{
+		Name:     "reflector",
+		Scheme:   "twamp",
+		Address:  ref.Addr().String(),
+		Count:    3,
+		Interval: 10 * time.Millisecond,
+		Timeout:  time.Second,
+	}
+	got, err := twampProber{}.Probe(context.Background(), target, Options{})
+	if err != nil {
+		t.Fatalf("Probe() error = %v", err)
+	}
+	if got.Status != StatusOK || got.IP != "127.0.0.1" {
+		t.Errorf("Probe() status, IP = %s, %s, want %s, 127.0.0.1", got.Status, got.IP, StatusOK)
+	}
+	if got.Sent != 3 || got.Drops != 0 {
+		t.Errorf("Probe() sent, drops = %d, %d, want 3, 0", got.Sent, got.Drops)
+	}
+	if got.Extra["FWDDROPS"] != 0 || got.Extra["REVDROPS"] != 0 {
+		t.Errorf("Probe() forward, reverse drops = %v, %v, want 0, 0", got.Extra["FWDDROPS"], got.Extra["REVDROPS"])
+	}
+	for _, metric := range []string{"FWD", "REV"} {
+		if delay, ok := got.Extra[metric].(float64); !ok || delay < 0 {
+			t.Errorf("Probe() %s = %v, want a delay", metric, got.Extra[metric])
+		}
+	}
+}
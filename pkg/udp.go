@@ -0,0 +1,183 @@
+// Copyright (c) 2020, Adam Vakil-Kirchberger
+// Licensed under the MIT license
+
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/adamkirchberger/pingsheet/pkg/config"
+)
+
+// DefaultReflectPort is the UDP port used by the reflector and by `udp://`
+// targets which do not set a port
+const DefaultReflectPort int = 8862
+
+// UDP echo packets have a header followed by padding to the target size. The
+// header holds the magic, type, tracker, sequence and send time set by the
+// sender, followed by the times the reflector received and sent the reply.
+const (
+	udpEchoMagic     uint32 = 0x50534845 // "PSHE"
+	udpEchoRequest   byte   = 1
+	udpEchoReply     byte   = 2
+	udpEchoHeaderLen int    = 48
+)
+
+func init() {
+	Register("udp", udpProber{})
+}
+
+// udpProber tests targets such as `udp://10.0.0.1:8862` by sending UDP echo
+// requests to a pingsheet reflector. The time the reflector took to answer is
+// removed from the RTT.
+type udpProber struct{}
+
+// Probe will run a single UDP echo test to a target
+func (udpProber) Probe(ctx context.Context, t config.Target, opts Options) (Result, error) {
+	conn, err := newDialer(t).DialContext(ctx, targetNetwork(t, "udp"), dialAddress(t, withPort(t, DefaultReflectPort)))
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	tracker := uint64(time.Now().UnixNano()) ^ uint64(atomic.AddUint32(&pingerCount, 1))
+	size := udpEchoHeaderLen
+	if t.Size > size {
+		size = t.Size
+	}
+
+	samples, err := exchangeUDP(ctx, t, conn,
+		func(seq int) []byte { return newUDPEcho(tracker, seq, size) },
+		func(b []byte, received time.Time) (int, time.Duration, bool) {
+			return parseUDPEcho(b, tracker, received)
+		},
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := NewResult(t, samples)
+	result.IP = remoteIP(conn)
+	return result, nil
+}
+
+// exchangeUDP will send the requests to a target on a connected UDP socket
+// and wait for the replies. The build function creates the request of a
+// sequence and parse returns the sequence and RTT of a reply, or false when
+// the packet is not a reply to these requests. The samples are returned in
+// the order that the requests were sent.
+func exchangeUDP(ctx context.Context, t config.Target, conn net.Conn,
+	build func(seq int) []byte,
+	parse func(b []byte, received time.Time) (int, time.Duration, bool)) ([]Sample, error) {
+	r := newBurstReplies(t.Count)
+	var refused int32
+	go readUDPReplies(conn, r, &refused, parse)
+
+	sent := 0
+	for seq := 0; seq < t.Count; seq++ {
+		if seq > 0 && !sleep(ctx, t.Interval) {
+			break
+		}
+		sent++
+		if _, err := conn.Write(build(seq)); err != nil {
+			if !errors.Is(err, syscall.ECONNREFUSED) {
+				return nil, err
+			}
+			atomic.StoreInt32(&refused, 1)
+		}
+	}
+
+	timeout := time.NewTimer(t.Timeout)
+	select {
+	case <-r.allRecv:
+	case <-timeout.C:
+	case <-ctx.Done():
+	}
+	timeout.Stop()
+
+	// Requests without a reply are unreachable when the target port is closed
+	if atomic.LoadInt32(&refused) == 1 {
+		for seq := 0; seq < sent; seq++ {
+			r.unreachable(seq)
+		}
+	}
+	return r.samples(sent), nil
+}
+
+// Metrics returns no additional metrics for UDP targets
+func (udpProber) Metrics(t config.Target) []string {
+	return nil
+}
+
+// withPort returns the address of a target with a port, the default port is
+// used when the target does not set a port
+func withPort(t config.Target, port int) string {
+	if _, _, err := net.SplitHostPort(t.Address); err == nil {
+		return t.Address
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(t.Address, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// newUDPEcho will create an echo request with the send time in the header
+func newUDPEcho(tracker uint64, seq, size int) []byte {
+	b := make([]byte, size)
+	binary.BigEndian.PutUint32(b[0:4], udpEchoMagic)
+	b[4] = udpEchoRequest
+	binary.BigEndian.PutUint64(b[8:16], tracker)
+	binary.BigEndian.PutUint32(b[16:20], uint32(seq))
+	binary.BigEndian.PutUint64(b[24:32], uint64(time.Now().UnixNano()))
+	return b
+}
+
+// readUDPReplies will read replies and record the RTT of each until the
+// connection is closed, refused is set when a port unreachable is received
+func readUDPReplies(conn net.Conn, r *burstReplies, refused *int32,
+	parse func(b []byte, received time.Time) (int, time.Duration, bool)) {
+	buf := make([]byte, 65536)
+	for {
+		n, err := conn.Read(buf)
+		received := time.Now()
+		if err != nil {
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				atomic.StoreInt32(refused, 1)
+				continue
+			}
+			// Connection has been closed
+			return
+		}
+		if seq, rtt, ok := parse(buf[:n], received); ok {
+			r.reply(seq, rtt)
+		}
+	}
+}
+
+// parseUDPEcho will return the sequence and RTT of an echo reply, false is
+// returned for packets which are not a reply to the tracker
+func parseUDPEcho(b []byte, tracker uint64, received time.Time) (int, time.Duration, bool) {
+	if len(b) < udpEchoHeaderLen || binary.BigEndian.Uint32(b[0:4]) != udpEchoMagic ||
+		b[4] != udpEchoReply || binary.BigEndian.Uint64(b[8:16]) != tracker {
+		return 0, 0, false
+	}
+
+	seq := int(binary.BigEndian.Uint32(b[16:20]))
+	sentAt := time.Unix(0, int64(binary.BigEndian.Uint64(b[24:32])))
+	rtt := received.Sub(sentAt)
+
+	// The reflector times are from its own clock so only the difference
+	// between them can be used
+	reflectRecv := int64(binary.BigEndian.Uint64(b[32:40]))
+	reflectSent := int64(binary.BigEndian.Uint64(b[40:48]))
+	if held := time.Duration(reflectSent - reflectRecv); held > 0 && held < rtt {
+		rtt -= held
+	}
+	return seq, rtt, true
+}